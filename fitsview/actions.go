@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// viewerAction describes an action of the viewer, run from the menu, the
+// command palette or a key binding.
+type viewerAction struct {
+	name  string
+	label string
+	keys  []string // default key bindings
+}
+
+// viewerActions lists the actions of the viewer, in the order of the menu.
+// Keys are named as in gdk, e.g. Right, plus or Page_Up, with the ctrl+
+// and alt+ modifiers. Shifted keys are given by their name, e.g. T.
+var viewerActions = []viewerAction{
+	{"next-file", "Next file", []string{"Right"}},
+	{"prev-file", "Previous file", []string{"Left"}},
+	{"next-hdu", "Next HDU", []string{"Up"}},
+	{"prev-hdu", "Previous HDU", []string{"Down"}},
+	{"next-plane", "Next plane", []string{"Page_Up"}},
+	{"prev-plane", "Previous plane", []string{"Page_Down"}},
+	{"zoom-in", "Zoom in", []string{"plus", "equal", "KP_Add"}},
+	{"zoom-out", "Zoom out", []string{"minus", "KP_Subtract"}},
+	{"zoom-100", "Zoom to 100%", []string{"1"}},
+	{"fit", "Fit to window", []string{"f"}},
+	{"pan-left", "Pan left", []string{"ctrl+Left"}},
+	{"pan-right", "Pan right", []string{"ctrl+Right"}},
+	{"pan-up", "Pan up", []string{"ctrl+Up"}},
+	{"pan-down", "Pan down", []string{"ctrl+Down"}},
+	{"cycle-stretch", "Next stretch", []string{"s"}},
+	{"cycle-colormap", "Next colormap", []string{"c"}},
+	{"resample", "Toggle bilinear resampling", []string{"i"}},
+	{"header", "Header", []string{"h"}},
+	{"stats", "Statistics", []string{"t"}},
+	{"tables", "Tables", []string{"T"}},
+	{"grid", "Thumbnail grid", []string{"g"}},
+	{"sources", "Catalogue sources", []string{"k"}},
+	{"mark", "Mark file for blink", []string{"m"}},
+	{"blink", "Blink marked files", []string{"b"}},
+	{"split", "Split view", []string{"v"}},
+	{"align", "Align on WCS", []string{"a"}},
+	{"rgb", "RGB composite", []string{"x"}},
+	{"pause", "Pause new files", []string{"w"}},
+	{"region-shape", "Region shape", []string{"r"}},
+	{"finish-polygon", "Finish polygon", []string{"Return"}},
+	{"delete-region", "Delete region", []string{"Delete", "BackSpace"}},
+	{"cancel", "Cancel selection", []string{"Escape"}},
+	{"save-regions", "Save regions", []string{"ctrl+s"}},
+	{"line-profile", "Line profile", []string{"l"}},
+	{"star-profile", "Star profile", []string{"p"}},
+	{"save-expr", "Save expression result", []string{"ctrl+e"}},
+	{"palette", "Command palette", []string{"ctrl+p"}},
+	{"quit", "Quit", []string{"q"}},
+}
+
+// isAction reports whether name is the name of an action.
+func isAction(name string) bool {
+	for _, a := range viewerActions {
+		if a.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// keyString returns the name of a key binding, the key name preceded by
+// the modifiers.
+func keyString(key string, ctrl, alt bool) string {
+	if alt {
+		key = "alt+" + key
+	}
+	if ctrl {
+		key = "ctrl+" + key
+	}
+	return key
+}
+
+// normalizeKey checks the key binding s, and returns it with the
+// modifiers in the order of keyString.
+func normalizeKey(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	fields := strings.Split(s, "+")
+	key := fields[len(fields)-1]
+	mods := fields[:len(fields)-1]
+	if key == "" && len(fields) >= 2 && fields[len(fields)-2] == "" {
+		// ctrl++
+		key, mods = "plus", fields[:len(fields)-2]
+	}
+	if key == "" {
+		return "", fmt.Errorf("invalid key %q", s)
+	}
+	var ctrl, alt bool
+	for _, m := range mods {
+		switch strings.ToLower(strings.TrimSpace(m)) {
+		case "ctrl", "control":
+			ctrl = true
+		case "alt":
+			alt = true
+		default:
+			return "", fmt.Errorf("invalid modifier %q in %q", m, s)
+		}
+	}
+	return keyString(strings.TrimSpace(key), ctrl, alt), nil
+}
+
+// keyName returns the gdk name of the key of a binding.
+func keyName(binding string) string {
+	if i := strings.LastIndex(binding, "+"); i >= 0 && i+1 < len(binding) {
+		return binding[i+1:]
+	}
+	return binding
+}
+
+// keyBindings holds the keys of the actions, indexed by action name.
+type keyBindings map[string][]string
+
+// defaultBindings returns the default keys of the actions.
+func defaultBindings() keyBindings {
+	b := make(keyBindings)
+	for _, a := range viewerActions {
+		b[a.name] = append([]string(nil), a.keys...)
+	}
+	return b
+}
+
+// readBindings reads key bindings, one action per line given as
+//
+//	ACTION = KEY[, KEY...]
+//
+// where an empty list of keys removes the bindings of the action. Lines
+// starting with # are comments.
+func readBindings(r io.Reader) (keyBindings, error) {
+	b := make(keyBindings)
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.Index(line, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: missing =", n)
+		}
+		name := strings.TrimSpace(line[:i])
+		if !isAction(name) {
+			return nil, fmt.Errorf("line %d: unknown action %q", n, name)
+		}
+		keys := []string{}
+		for _, k := range strings.Split(line[i+1:], ",") {
+			if strings.TrimSpace(k) == "" {
+				continue
+			}
+			key, err := normalizeKey(k)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", n, err)
+			}
+			keys = append(keys, key)
+		}
+		b[name] = keys
+	}
+	return b, sc.Err()
+}
+
+// loadBindings reads the key bindings of the file name, see readBindings.
+func loadBindings(name string) (keyBindings, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	b, err := readBindings(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return b, nil
+}
+
+// defaultBindingsFile returns the key bindings file in the user config
+// directory, or an empty string if there is none.
+func defaultBindingsFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "fitsview", "keys.conf")
+}
+
+// override replaces the keys of the actions of user. Their keys are
+// removed from the other actions.
+func (b keyBindings) override(user keyBindings) {
+	used := make(map[string]bool)
+	for _, keys := range user {
+		for _, k := range keys {
+			used[k] = true
+		}
+	}
+	for name, keys := range b {
+		var kept []string
+		for _, k := range keys {
+			if !used[k] {
+				kept = append(kept, k)
+			}
+		}
+		b[name] = kept
+	}
+	for name, keys := range user {
+		b[name] = keys
+	}
+}
+
+// actionKeys returns the actions indexed by key. A key bound to several
+// actions runs the first one.
+func (b keyBindings) actionKeys() map[string]string {
+	m := make(map[string]string)
+	for _, a := range viewerActions {
+		for _, k := range b[a.name] {
+			if _, found := m[k]; !found {
+				m[k] = a.name
+			}
+		}
+	}
+	return m
+}
+
+// shortcut returns the keys of the action name, for the menu and the
+// command palette.
+func (b keyBindings) shortcut(name string) string {
+	return strings.Join(b[name], ", ")
+}
+
+// matchActions returns the actions whose label, name or keys contain all
+// the words of query, ignoring the case.
+func matchActions(b keyBindings, query string) []viewerAction {
+	words := strings.Fields(strings.ToLower(query))
+	var actions []viewerAction
+	for _, a := range viewerActions {
+		text := strings.ToLower(a.label + " " + a.name + " " + b.shortcut(a.name))
+		match := true
+		for _, w := range words {
+			if !strings.Contains(text, w) {
+				match = false
+				break
+			}
+		}
+		if match {
+			actions = append(actions, a)
+		}
+	}
+	return actions
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDefaultBindings(t *testing.T) {
+	seen := make(map[string]string)
+	for _, a := range viewerActions {
+		for _, k := range a.keys {
+			if other, found := seen[k]; found {
+				t.Fatalf("key %q bound to %s and %s", k, other, a.name)
+			}
+			seen[k] = a.name
+			if got, err := normalizeKey(k); err != nil || got != k {
+				t.Fatalf("normalizeKey(%q): got=%q %v", k, got, err)
+			}
+		}
+	}
+}
+
+func TestNormalizeKey(t *testing.T) {
+	for _, table := range []struct {
+		key  string
+		want string
+		ok   bool
+	}{
+		{"s", "s", true},
+		{" Page_Up ", "Page_Up", true},
+		{"Ctrl+s", "ctrl+s", true},
+		{"alt+control+Left", "ctrl+alt+Left", true},
+		{"ctrl++", "ctrl+plus", true},
+		{"shift+t", "", false},
+		{"ctrl+", "", false},
+		{"", "", false},
+	} {
+		got, err := normalizeKey(table.key)
+		if (err == nil) != table.ok || got != table.want {
+			t.Fatalf("normalizeKey(%q): got=%q %v, want=%q", table.key, got, err, table.want)
+		}
+	}
+	if got := keyName("ctrl+alt+Left"); got != "Left" {
+		t.Fatalf("got=%q, want=%q", got, "Left")
+	}
+}
+
+func TestReadBindings(t *testing.T) {
+	const text = `# my keys
+next-file = n, Right
+zoom-in = ctrl+plus
+cycle-stretch = Control+s
+pan-left =
+`
+	user, err := readBindings(strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := defaultBindings()
+	b.override(user)
+	for _, table := range []struct {
+		name string
+		want []string
+	}{
+		{"next-file", []string{"n", "Right"}},
+		{"zoom-in", []string{"ctrl+plus"}},
+		{"cycle-stretch", []string{"ctrl+s"}},
+		// ctrl+s is taken by cycle-stretch.
+		{"save-regions", nil},
+		{"pan-left", []string{}},
+		{"prev-file", []string{"Left"}},
+	} {
+		if got := b[table.name]; !reflect.DeepEqual(got, table.want) {
+			t.Fatalf("%s: got=%q, want=%q", table.name, got, table.want)
+		}
+	}
+	keys := b.actionKeys()
+	if keys["n"] != "next-file" || keys["ctrl+s"] != "cycle-stretch" || keys["ctrl+Left"] != "" {
+		t.Fatalf("got=%v", keys)
+	}
+	if got := b.shortcut("next-file"); got != "n, Right" {
+		t.Fatalf("got=%q, want=%q", got, "n, Right")
+	}
+
+	for _, text := range []string{"zoom", "unknown = z", "zoom-in = hyper+z"} {
+		if _, err := readBindings(strings.NewReader(text)); err == nil {
+			t.Fatalf("expected an error for %q", text)
+		}
+	}
+}
+
+func TestMatchActions(t *testing.T) {
+	b := defaultBindings()
+	names := func(actions []viewerAction) []string {
+		var s []string
+		for _, a := range actions {
+			s = append(s, a.name)
+		}
+		return s
+	}
+	if got := matchActions(b, ""); len(got) != len(viewerActions) {
+		t.Fatalf("got=%d actions, want=%d", len(got), len(viewerActions))
+	}
+	for _, table := range []struct {
+		query string
+		want  []string
+	}{
+		{"zoom", []string{"zoom-in", "zoom-out", "zoom-100"}},
+		{"PREV file", []string{"prev-file"}},
+		{"ctrl+s", []string{"save-regions"}},
+		{"nothing", nil},
+	} {
+		if got := names(matchActions(b, table.query)); !reflect.DeepEqual(got, table.want) {
+			t.Fatalf("matchActions(%q): got=%v, want=%v", table.query, got, table.want)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/saimn/margo/fitsview/wcs"
+)
+
+// Usual names of the right ascension and declination columns.
+var (
+	raColumns  = []string{"RA", "RAJ2000", "RA_ICRS", "ALPHA_J2000", "ALPHAWIN_J2000", "RADEG"}
+	decColumns = []string{"DEC", "DEJ2000", "DECJ2000", "DE_ICRS", "DELTA_J2000", "DELTAWIN_J2000", "DECDEG"}
+)
+
+// catalog is a table of sources overlaid on the images.
+type catalog struct {
+	*tableData
+	name     string
+	ra, dec  []float64 // position of the sources, in degrees, NaN if invalid
+	labelCol int       // column of the labels, -1 if none
+}
+
+// readCSV reads a CSV table whose first line holds the column names.
+// Cells are numbers when they can be parsed as such.
+func readCSV(r io.Reader) (*tableData, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+	t := &tableData{}
+	for _, name := range header {
+		t.cols = append(t.cols, tableColumn{name: strings.TrimSpace(name)})
+	}
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return t, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		row := make([]interface{}, len(rec))
+		for i, s := range rec {
+			s = strings.TrimSpace(s)
+			if v, err := strconv.ParseFloat(s, 64); err == nil {
+				row[i] = v
+			} else {
+				row[i] = s
+			}
+		}
+		t.rows = append(t.rows, row)
+	}
+}
+
+// loadCatalog reads the catalogue spec, a CSV file or a FITS table given
+// as a file name followed by an optional HDU index in brackets. The first
+// table is used when no index is given.
+func loadCatalog(spec, raName, decName, labelName string) (*catalog, error) {
+	var t *tableData
+	if strings.HasSuffix(strings.ToLower(spec), ".csv") {
+		r, err := openStream(spec)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		if t, err = readCSV(r); err != nil {
+			return nil, fmt.Errorf("%s: %v", spec, err)
+		}
+	} else {
+		name, hdu, err := parseChannel(spec)
+		if err != nil {
+			return nil, err
+		}
+		r, err := openStream(name)
+		if err != nil {
+			return nil, err
+		}
+		_, tables, err := scanFITS(r, name)
+		r.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, desc := range tables {
+			if hdu < 0 || desc.hdu == hdu {
+				t, err = readTable(desc)
+				if err != nil {
+					return nil, err
+				}
+				break
+			}
+		}
+		if t == nil {
+			return nil, fmt.Errorf("%s: no table", spec)
+		}
+	}
+	return newCatalog(spec, t, raName, decName, labelName)
+}
+
+// findColumn returns the index of the first column named as one of
+// names, ignoring the case, -1 if none.
+func findColumn(cols []tableColumn, names ...string) int {
+	for _, name := range names {
+		for i, c := range cols {
+			if strings.EqualFold(c.name, name) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// newCatalog returns the catalogue of the sources of t, whose positions
+// are in the columns raName and decName, or in the usual columns when
+// empty. Positions are numbers in degrees, or sexagesimal strings.
+func newCatalog(name string, t *tableData, raName, decName, labelName string) (*catalog, error) {
+	column := func(want string, usual []string) (int, error) {
+		if want != "" {
+			usual = []string{want}
+		}
+		i := findColumn(t.cols, usual...)
+		if i < 0 {
+			return -1, fmt.Errorf("%s: no column %s", name, strings.Join(usual, " or "))
+		}
+		return i, nil
+	}
+	raCol, err := column(raName, raColumns)
+	if err != nil {
+		return nil, err
+	}
+	decCol, err := column(decName, decColumns)
+	if err != nil {
+		return nil, err
+	}
+	c := &catalog{tableData: t, name: name, labelCol: -1}
+	if labelName != "" {
+		if c.labelCol, err = column(labelName, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	c.ra = make([]float64, len(t.rows))
+	c.dec = make([]float64, len(t.rows))
+	for i, row := range t.rows {
+		c.ra[i] = cellAngle(row[raCol], wcs.ParseRA)
+		c.dec[i] = cellAngle(row[decCol], wcs.ParseDec)
+	}
+	return c, nil
+}
+
+// cellAngle returns the angle of a cell, in degrees, parsing strings
+// with parse. It returns NaN if the cell is not an angle.
+func cellAngle(v interface{}, parse func(string) (float64, error)) float64 {
+	if x, ok := cellFloat(v); ok {
+		return x
+	}
+	if s, ok := v.(string); ok {
+		if x, err := parse(s); err == nil {
+			return x
+		}
+	}
+	return math.NaN()
+}
+
+// label returns the label of the source i, empty if none.
+func (c *catalog) label(i int) string {
+	if c.labelCol < 0 {
+		return ""
+	}
+	return formatCell(c.rows[i][c.labelCol])
+}
+
+// position returns the image position of the source i through w, false
+// if it can not be projected.
+func (c *catalog) position(w *wcs.WCS, i int) (fpoint, bool) {
+	if w == nil || math.IsNaN(c.ra[i]) || math.IsNaN(c.dec[i]) {
+		return fpoint{}, false
+	}
+	x, y, ok := w.WorldToPixel(c.ra[i], c.dec[i])
+	if !ok {
+		return fpoint{}, false
+	}
+	// FITS pixel coordinates start at 1 at the centre of the first pixel.
+	return fpoint{x - 0.5, y - 0.5}, true
+}
+
+// nearest returns the source closest to the image position p, within
+// tol pixels, -1 if none.
+func (c *catalog) nearest(w *wcs.WCS, p fpoint, tol float64) int {
+	best, bestDist := -1, tol
+	for i := range c.ra {
+		q, ok := c.position(w, i)
+		if !ok {
+			continue
+		}
+		if d := math.Hypot(q.x-p.x, q.y-p.y); d <= bestDist {
+			best, bestDist = i, d
+		}
+	}
+	return best
+}
+
+// describe returns the values of the row i, one column per line.
+func (c *catalog) describe(i int) string {
+	var b strings.Builder
+	for j, col := range c.cols {
+		fmt.Fprintf(&b, "%s = %s", col.name, formatCell(c.rows[i][j]))
+		if col.unit != "" {
+			fmt.Fprintf(&b, " %s", col.unit)
+		}
+		b.WriteString("\n")
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
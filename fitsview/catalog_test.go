@@ -0,0 +1,111 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestReadCSV(t *testing.T) {
+	const text = `# sources
+id, ra, dec, name
+1, 10.0, 20.0, M 31
+2, 00:40:00, +20:01:00, "a, b"
+`
+	data, err := readCSV(strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data.cols) != 4 || data.cols[3].name != "name" {
+		t.Fatalf("got=%v, want 4 columns", data.cols)
+	}
+	if len(data.rows) != 2 {
+		t.Fatalf("got=%d rows, want=2", len(data.rows))
+	}
+	if v, ok := data.rows[0][1].(float64); !ok || v != 10 {
+		t.Fatalf("got=%#v, want=10.0", data.rows[0][1])
+	}
+	if v := data.rows[1][3]; v != "a, b" {
+		t.Fatalf("got=%#v, want=%q", v, "a, b")
+	}
+
+	if _, err := readCSV(strings.NewReader("a,b\n1,2,3\n")); err == nil {
+		t.Fatalf("expected an error for a row with too many fields")
+	}
+}
+
+func TestFindColumn(t *testing.T) {
+	cols := []tableColumn{{name: "ID"}, {name: "RAJ2000"}, {name: "DEJ2000"}}
+	for _, table := range []struct {
+		names []string
+		want  int
+	}{
+		{raColumns, 1},
+		{decColumns, 2},
+		{[]string{"id"}, 0},
+		{[]string{"MAG"}, -1},
+	} {
+		if got := findColumn(cols, table.names...); got != table.want {
+			t.Fatalf("findColumn(%v): got=%d, want=%d", table.names, got, table.want)
+		}
+	}
+}
+
+func TestCatalog(t *testing.T) {
+	data := &tableData{
+		cols: []tableColumn{{name: "NAME"}, {name: "ALPHA"}, {name: "DELTA"}, {name: "MAG", unit: "mag"}},
+		rows: [][]interface{}{
+			{"center", 10.0, 20.0, float32(12)},
+			{"sexagesimal", "00:40:00", "+20:00:36", float32(13)},
+			{"invalid", "?", 20.0, float32(14)},
+		},
+	}
+	if _, err := newCatalog("test", data, "", "", ""); err == nil {
+		t.Fatalf("expected an error without a RA column")
+	}
+	if _, err := newCatalog("test", data, "ALPHA", "DELTA", "LABEL"); err == nil {
+		t.Fatalf("expected an error without the label column")
+	}
+	c, err := newCatalog("test", data, "alpha", "delta", "name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ra[1] != 10 || math.Abs(c.dec[1]-20.01) > 1e-9 || !math.IsNaN(c.ra[2]) {
+		t.Fatalf("got ra=%v dec=%v", c.ra, c.dec)
+	}
+	if got := c.label(1); got != "sexagesimal" {
+		t.Fatalf("got=%q, want=%q", got, "sexagesimal")
+	}
+	if got, want := c.describe(0), "NAME = center\nALPHA = 10\nDELTA = 20\nMAG = 12 mag"; got != want {
+		t.Fatalf("got=%q, want=%q", got, want)
+	}
+
+	// The reference pixel is at the centre of the pixel (100, 100).
+	w := tanWCS(t, 100.5, 0.001)
+	p, ok := c.position(w, 0)
+	if !ok || math.Abs(p.x-100) > 1e-6 || math.Abs(p.y-100) > 1e-6 {
+		t.Fatalf("got=%v %v, want={100 100}", p, ok)
+	}
+	if _, ok := c.position(w, 2); ok {
+		t.Fatalf("expected no position for an invalid source")
+	}
+	if _, ok := c.position(nil, 0); ok {
+		t.Fatalf("expected no position without WCS")
+	}
+
+	// The second source is 0.01 degree, 10 pixels, to the north.
+	for _, table := range []struct {
+		p    fpoint
+		tol  float64
+		want int
+	}{
+		{fpoint{101, 101}, 3, 0},
+		{fpoint{100, 108}, 3, 1},
+		{fpoint{100, 105}, 3, -1},
+		{fpoint{150, 150}, 3, -1},
+	} {
+		if got := c.nearest(w, table.p, table.tol); got != table.want {
+			t.Fatalf("nearest(%v): got=%d, want=%d", table.p, got, table.want)
+		}
+	}
+}
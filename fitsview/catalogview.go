@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/gotk3/gotk3/gtk"
+)
+
+// sourceView is the window showing the values of a source of the
+// catalogue.
+type sourceView struct {
+	*gtk.Window
+	text *gtk.Label
+}
+
+func sourceWindow(parent *gtk.ApplicationWindow) *sourceView {
+	win, err := gtk.WindowNew(gtk.WINDOW_TOPLEVEL)
+	if err != nil {
+		log.Fatal("Unable to create window:", err)
+	}
+	win.SetTitle("Source")
+	win.SetTransientFor(parent)
+	win.SetDefaultSize(350, 400)
+	win.HideOnDelete()
+
+	scroll, err := gtk.ScrolledWindowNew(nil, nil)
+	if err != nil {
+		log.Fatal("Unable to create scrolled window:", err)
+	}
+	win.Add(scroll)
+	text, err := gtk.LabelNew("")
+	if err != nil {
+		log.Fatal("Unable to create label:", err)
+	}
+	text.SetXAlign(0)
+	text.SetSelectable(true)
+	scroll.Add(text)
+	scroll.ShowAll()
+	return &sourceView{Window: win, text: text}
+}
+
+// show shows the values of the source i of c.
+func (v *sourceView) show(c *catalog, i int) {
+	title := fmt.Sprintf("Source %d of %s", i+1, c.name)
+	if l := c.label(i); l != "" {
+		title += " (" + l + ")"
+	}
+	v.SetTitle(title)
+	v.text.SetText(c.describe(i))
+	v.Present()
+}
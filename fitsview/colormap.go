@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// Colormap maps a display intensity to a colour.
+type Colormap struct {
+	Name string
+	lut  [256]color.RGBA
+}
+
+// At returns the colour of the display intensity v.
+func (cm *Colormap) At(v byte) color.RGBA {
+	return cm.lut[v]
+}
+
+// colorStop is the colour of a colormap at the position x in [0, 1].
+type colorStop struct {
+	x       float64
+	r, g, b float64
+}
+
+// newColormap builds the lookup table of a colormap by linear
+// interpolation between the stops, which must be sorted by position.
+func newColormap(name string, stops []colorStop) *Colormap {
+	cm := &Colormap{Name: name}
+	j := 0
+	for i := range cm.lut {
+		x := float64(i) / 255
+		for j < len(stops)-2 && x > stops[j+1].x {
+			j++
+		}
+		s0, s1 := stops[j], stops[j+1]
+		t := (x - s0.x) / (s1.x - s0.x)
+		if t < 0 {
+			t = 0
+		} else if t > 1 {
+			t = 1
+		}
+		cm.lut[i] = color.RGBA{
+			R: uint8(255*(s0.r+t*(s1.r-s0.r)) + 0.5),
+			G: uint8(255*(s0.g+t*(s1.g-s0.g)) + 0.5),
+			B: uint8(255*(s0.b+t*(s1.b-s0.b)) + 0.5),
+			A: 255,
+		}
+	}
+	return cm
+}
+
+// hexStops returns evenly spaced stops from 0xRRGGBB colours.
+func hexStops(colours ...uint32) []colorStop {
+	stops := make([]colorStop, len(colours))
+	for i, c := range colours {
+		stops[i] = colorStop{
+			x: float64(i) / float64(len(colours)-1),
+			r: float64(c>>16&0xff) / 255,
+			g: float64(c>>8&0xff) / 255,
+			b: float64(c&0xff) / 255,
+		}
+	}
+	return stops
+}
+
+// colormapNames lists the available colormaps, in the order used when
+// cycling through them in the viewer.
+var colormapNames = []string{"gray", "gray-inverted", "viridis", "inferno", "magma", "heat", "cool"}
+
+var colormaps = map[string]*Colormap{
+	"gray":          newColormap("gray", []colorStop{{0, 0, 0, 0}, {1, 1, 1, 1}}),
+	"gray-inverted": newColormap("gray-inverted", []colorStop{{0, 1, 1, 1}, {1, 0, 0, 0}}),
+	// The matplotlib perceptual colormaps, sampled every 1/8.
+	"viridis": newColormap("viridis", hexStops(
+		0x440154, 0x472d7b, 0x3b528b, 0x2c728e, 0x21918c,
+		0x28ae80, 0x5ec962, 0xaddc30, 0xfde725,
+	)),
+	"inferno": newColormap("inferno", hexStops(
+		0x000004, 0x1b0c41, 0x4a0c6b, 0x781c6d, 0xa52c60,
+		0xcf4446, 0xed6925, 0xfb9b06, 0xfcffa4,
+	)),
+	"magma": newColormap("magma", hexStops(
+		0x000004, 0x1c1044, 0x4f127b, 0x812581, 0xb5367a,
+		0xe55064, 0xfb8761, 0xfec287, 0xfcfdbf,
+	)),
+	// The DS9 heat colormap.
+	"heat": newColormap("heat", []colorStop{
+		{0, 0, 0, 0}, {0.34, 1, 0.34, 0}, {0.65, 1, 0.65, 0}, {0.98, 1, 0.98, 1}, {1, 1, 1, 1},
+	}),
+	"cool": newColormap("cool", []colorStop{{0, 0, 1, 1}, {1, 1, 0, 1}}),
+}
+
+// lookupColormap returns the colormap called name.
+func lookupColormap(name string) (*Colormap, error) {
+	cm, ok := colormaps[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown colormap %q", name)
+	}
+	return cm, nil
+}
+
+// nextColormap returns the name of the colormap following name.
+func nextColormap(name string) string {
+	for i, n := range colormapNames {
+		if n == name {
+			return colormapNames[(i+1)%len(colormapNames)]
+		}
+	}
+	return colormapNames[0]
+}
+
+// colorbar returns a horizontal strip showing the colormap through the
+// stretch, from vmin on the left to vmax on the right.
+func colorbar(cm *Colormap, stretch Stretch, width, height int) *image.RGBA {
+	rgba := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		v := uint32ToByte(uint32(stretch.Apply(float64(x)/float64(width-1)) * maxUint32))
+		c := cm.At(v)
+		for y := 0; y < height; y++ {
+			rgba.SetRGBA(x, y, c)
+		}
+	}
+	return rgba
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestColormaps(t *testing.T) {
+	for _, name := range colormapNames {
+		cm, err := lookupColormap(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cm.Name != name {
+			t.Fatalf("invalid name: got=%q, want=%q", cm.Name, name)
+		}
+	}
+	if len(colormaps) != len(colormapNames) {
+		t.Fatalf("colormapNames and colormaps are out of sync")
+	}
+	if _, err := lookupColormap("foo"); err == nil {
+		t.Fatalf("expected an error for an unknown colormap")
+	}
+
+	for _, table := range []struct {
+		name string
+		v    byte
+		want color.RGBA
+	}{
+		{name: "gray", v: 0, want: color.RGBA{0, 0, 0, 255}},
+		{name: "gray", v: 128, want: color.RGBA{128, 128, 128, 255}},
+		{name: "gray-inverted", v: 255, want: color.RGBA{0, 0, 0, 255}},
+		{name: "viridis", v: 0, want: color.RGBA{0x44, 0x01, 0x54, 255}},
+		{name: "viridis", v: 255, want: color.RGBA{0xfd, 0xe7, 0x25, 255}},
+		{name: "cool", v: 0, want: color.RGBA{0, 255, 255, 255}},
+		{name: "heat", v: 255, want: color.RGBA{255, 255, 255, 255}},
+	} {
+		if got := colormaps[table.name].At(table.v); got != table.want {
+			t.Fatalf("%s(%d): got=%v, want=%v", table.name, table.v, got, table.want)
+		}
+	}
+}
+
+func TestColorbar(t *testing.T) {
+	rgba := colorbar(colormaps["gray"], linearStretch{}, 256, 4)
+	if c := rgba.RGBAAt(0, 3); c.R != 0 {
+		t.Fatalf("invalid left colour: %v", c)
+	}
+	if c := rgba.RGBAAt(255, 0); c.R != 255 {
+		t.Fatalf("invalid right colour: %v", c)
+	}
+}
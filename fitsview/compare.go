@@ -0,0 +1,67 @@
+package main
+
+import (
+	"image"
+	"math"
+	"sort"
+)
+
+// toggleMark adds the file i to the sorted list of marked files, or
+// removes it if it is already marked.
+func toggleMark(marked []int, i int) []int {
+	k := sort.SearchInts(marked, i)
+	if isMarked(marked, i) {
+		return append(marked[:k], marked[k+1:]...)
+	}
+	marked = append(marked, 0)
+	copy(marked[k+1:], marked[k:])
+	marked[k] = i
+	return marked
+}
+
+// isMarked reports whether the file i is in the sorted list of marked
+// files.
+func isMarked(marked []int, i int) bool {
+	k := sort.SearchInts(marked, i)
+	return k < len(marked) && marked[k] == i
+}
+
+// nextMarked returns the first marked file after the file i, wrapping
+// around the list.
+func nextMarked(marked []int, i int) int {
+	k := sort.SearchInts(marked, i+1)
+	if k == len(marked) {
+		k = 0
+	}
+	return marked[k]
+}
+
+// alignView sets the scale and origin of dst so that it shows the same
+// part of the image as src in a width x height window. When align is true
+// and both images have a WCS, dst shows the same part of the sky, at the
+// same angular scale. Rotations between the images are not corrected.
+func alignView(dst, src *imageInfo, width, height int, align bool) {
+	dst.fit = false
+	if !align || dst.hduData == nil || src.hduData == nil || dst.wcs == nil || src.wcs == nil {
+		dst.scale, dst.orig = src.scale, src.orig
+		return
+	}
+
+	scale := float64(src.scale) * dst.wcs.PixelScale() / src.wcs.PixelScale()
+	dst.scale = int(math.Max(1, math.Round(scale)))
+
+	// Sky position at the centre of the window.
+	cx, cy := float64(width)/2, float64(height)/2
+	ix, iy := src.toImage(cx, cy)
+	ra, dec := src.wcs.PixelToWorld(ix+0.5, iy+0.5)
+	x, y, ok := dst.wcs.WorldToPixel(ra, dec)
+	if !ok {
+		dst.orig = src.orig
+		return
+	}
+	s := float64(dst.scale) / 100
+	dst.orig = image.Point{
+		X: int(math.Round(x - 0.5 - cx/s)),
+		Y: int(math.Round(y - 0.5 - cy/s)),
+	}
+}
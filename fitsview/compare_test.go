@@ -0,0 +1,78 @@
+package main
+
+import (
+	"image"
+	"reflect"
+	"testing"
+
+	"github.com/saimn/fitsio"
+	"github.com/saimn/margo/fitsview/wcs"
+)
+
+func TestMarks(t *testing.T) {
+	var marked []int
+	for _, i := range []int{4, 1, 7, 4, 2} {
+		marked = toggleMark(marked, i)
+	}
+	if want := []int{1, 2, 7}; !reflect.DeepEqual(marked, want) {
+		t.Fatalf("got=%v, want=%v", marked, want)
+	}
+	if !isMarked(marked, 7) || isMarked(marked, 4) {
+		t.Fatalf("isMarked does not match %v", marked)
+	}
+	for _, table := range []struct{ i, want int }{{1, 2}, {2, 7}, {7, 1}, {5, 7}, {0, 1}} {
+		if got := nextMarked(marked, table.i); got != table.want {
+			t.Fatalf("nextMarked(%d): got=%d, want=%d", table.i, got, table.want)
+		}
+	}
+}
+
+func tanWCS(t *testing.T, crpix, cdelt float64) *wcs.WCS {
+	hdr := fitsio.NewHeader([]fitsio.Card{
+		{Name: "CTYPE1", Value: "RA---TAN"},
+		{Name: "CTYPE2", Value: "DEC--TAN"},
+		{Name: "CRPIX1", Value: crpix},
+		{Name: "CRPIX2", Value: crpix},
+		{Name: "CRVAL1", Value: 10.0},
+		{Name: "CRVAL2", Value: 20.0},
+		{Name: "CDELT1", Value: -cdelt},
+		{Name: "CDELT2", Value: cdelt},
+	}, fitsio.IMAGE_HDU, -32, []int{200, 200})
+	w, err := wcs.New(hdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func TestAlignView(t *testing.T) {
+	src := &imageInfo{
+		hduDesc: hduDesc{axes: []int{200, 200}, planes: 1},
+		hduData: &hduData{wcs: tanWCS(t, 100.5, 0.001)},
+		scale:   200,
+		orig:    image.Point{50, 60},
+		fit:     true,
+	}
+	// Shifted by 20 pixels, with pixels twice as large.
+	dst := &imageInfo{
+		hduDesc: hduDesc{axes: []int{200, 200}, planes: 1},
+		hduData: &hduData{wcs: tanWCS(t, 120.5, 0.002)},
+		scale:   100,
+		fit:     true,
+	}
+
+	alignView(dst, src, 100, 80, false)
+	if dst.scale != 200 || dst.orig != src.orig || dst.fit {
+		t.Fatalf("view not copied: scale=%d orig=%v", dst.scale, dst.orig)
+	}
+
+	alignView(dst, src, 100, 80, true)
+	// The centre of the window shows the src pixel (75, 80), 25 and 20
+	// pixels from the reference pixel, which are 12.5 and 10 dst pixels.
+	if dst.scale != 400 {
+		t.Fatalf("got scale=%d, want 400", dst.scale)
+	}
+	if want := (image.Point{X: 120 - 13 - 12, Y: 120 - 10 - 10}); dst.orig != want {
+		t.Fatalf("got orig=%v, want %v", dst.orig, want)
+	}
+}
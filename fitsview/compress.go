@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+)
+
+// decompress returns a reader of the uncompressed stream when rc is gzip
+// or bzip2 compressed, as detected from its magic bytes. Otherwise the
+// stream is returned unchanged, and stays seekable if rc is.
+func decompress(rc io.ReadCloser) (io.ReadCloser, error) {
+	br := bufio.NewReader(rc)
+	magic, _ := br.Peek(3)
+
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			rc.Close()
+			return nil, err
+		}
+		return readCloser{zr, func() error {
+			zr.Close()
+			return rc.Close()
+		}}, nil
+
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		return readCloser{bzip2.NewReader(br), rc.Close}, nil
+	}
+
+	if s, ok := rc.(io.Seeker); ok {
+		if _, err := s.Seek(0, io.SeekStart); err == nil {
+			return rc, nil
+		}
+	}
+	return readCloser{br, rc.Close}, nil
+}
+
+// readCloser is a reader with a custom Close method.
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (rc readCloser) Close() error {
+	return rc.close()
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"testing"
+)
+
+func TestDecompress(t *testing.T) {
+	const want = "SIMPLE  =                    T"
+
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte(want))
+	zw.Close()
+
+	// printf 'SIMPLE  =                    T' | bzip2
+	bz2 := []byte{
+		0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x60, 0x14,
+		0xbb, 0x2f, 0x00, 0x00, 0x06, 0xbe, 0x00, 0x40, 0x00, 0x40, 0x00, 0x00,
+		0x02, 0x02, 0x26, 0x4c, 0x00, 0x20, 0x00, 0x21, 0xa3, 0x40, 0xc8, 0x40,
+		0x0c, 0x24, 0x03, 0x44, 0x62, 0x5c, 0x9b, 0xf1, 0x77, 0x24, 0x53, 0x85,
+		0x09, 0x06, 0x01, 0x4b, 0xb2, 0xf0,
+	}
+
+	for _, table := range []struct {
+		name string
+		data []byte
+	}{
+		{name: "plain", data: []byte(want)},
+		{name: "gzip", data: gz.Bytes()},
+		{name: "bzip2", data: bz2},
+	} {
+		r, err := decompress(ioutil.NopCloser(bytes.NewReader(table.data)))
+		if err != nil {
+			t.Fatalf("%s: %v", table.name, err)
+		}
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%s: %v", table.name, err)
+		}
+		if err := r.Close(); err != nil {
+			t.Fatalf("%s: %v", table.name, err)
+		}
+		if string(got) != want {
+			t.Fatalf("%s: got=%q, want=%q", table.name, got, want)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+)
+
+// setPlane decodes the plane k of a data cube, the display quantiles of
+// the image are kept.
+func (img *imageInfo) setPlane(k int) error {
+	if k == img.plane {
+		return nil
+	}
+	if k < 0 || k >= img.planes {
+		return fmt.Errorf("invalid plane %d (cube has %d planes)", k+1, img.planes)
+	}
+	size := img.df.bitpix / 8
+	if size < 0 {
+		size = -size
+	}
+	n := img.Width * img.Height * size
+	if len(img.raw) < (k+1)*n {
+		return fmt.Errorf("truncated data cube")
+	}
+	plane, err := decodeImage(img.raw[k*n:], img.Width, img.Height, img.df)
+	if err != nil {
+		return err
+	}
+	img.floatImage = plane
+	img.plane = k
+	img.pixels = nil
+	return nil
+}
+
+// planeLabel describes the current plane of a data cube, with its
+// spectral coordinate when the header has one.
+func (img *imageInfo) planeLabel() string {
+	if img.planes <= 1 {
+		return ""
+	}
+	label := fmt.Sprintf("plane %d/%d", img.plane+1, img.planes)
+	if img.spec != nil {
+		label += fmt.Sprintf(" %s=%g %s", img.spec.CType, img.spec.Value(float64(img.plane+1)), img.spec.CUnit)
+	}
+	return label
+}
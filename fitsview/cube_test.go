@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/saimn/margo/fitsview/wcs"
+)
+
+func TestSetPlane(t *testing.T) {
+	df := dataFormat{bitpix: 8, bscale: 1}
+	raw := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+	first, err := decodeImage(raw, 2, 2, df)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := &imageInfo{
+		hduDesc: hduDesc{axes: []int{2, 2, 3}, planes: 3},
+		hduData: &hduData{
+			floatImage: first,
+			raw:        raw,
+			df:         df,
+			spec:       &wcs.Axis{CType: "FREQ", CUnit: "Hz", CRPix: 1, CRVal: 1e9, CDelt: 1e6},
+		},
+	}
+	img.sortedPixels()
+
+	if err := img.setPlane(2); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := img.value(1, 1); v != 12 {
+		t.Fatalf("value(1, 1) of plane 3: got=%v, want=12", v)
+	}
+	if img.pixels != nil {
+		t.Fatalf("sorted pixels of the previous plane were kept")
+	}
+	if got, want := img.planeLabel(), "plane 3/3 FREQ=1.002e+09 Hz"; got != want {
+		t.Fatalf("invalid label\ngot =%q\nwant=%q\n", got, want)
+	}
+	if err := img.setPlane(3); err == nil {
+		t.Fatalf("expected an error for an invalid plane")
+	}
+}
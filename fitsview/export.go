@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// exportImages writes one PNG file per image HDU in dir, without
+// starting the GTK application.
+func exportImages(infos []fileInfo, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if *rgbSpec != "" {
+		return exportRGB(infos, dir)
+	}
+
+	for _, finfo := range infos {
+		for i := range finfo.Images {
+			img := &finfo.Images[i]
+			if err := images.load(img); err != nil {
+				return err
+			}
+			name := filepath.Join(dir, exportName(finfo.Name, img.hdu))
+			qmin, qmax, err := computeQuantiles(img, img.qmin, img.qmax)
+			if err != nil {
+				// There is nothing to stretch, the image is transparent.
+				log.Printf("%s[%d]: %v\n", finfo.Name, img.hdu, err)
+				if err := savePNG(name, image.NewRGBA(img.Bounds())); err != nil {
+					return err
+				}
+				log.Printf("exported %s\n", name)
+				continue
+			}
+			stretch, err := newStretch(*stretchName, img.sortedPixels(), qmin, qmax)
+			if err != nil {
+				return err
+			}
+			cmap, err := lookupColormap(*cmapName)
+			if err != nil {
+				return err
+			}
+
+			if err := writePNG(name, img, qmin, qmax, stretch, cmap); err != nil {
+				return err
+			}
+			log.Printf("exported %s\n", name)
+		}
+	}
+
+	return nil
+}
+
+// exportRGB writes the RGB composite of the channels in dir.
+func exportRGB(infos []fileInfo, dir string) error {
+	chans, err := loadChannels(infos)
+	if err != nil {
+		return err
+	}
+	rgba, err := composeRGB(chans, rgbFlags(chans[0].qmin, chans[0].qmax))
+	if err != nil {
+		return err
+	}
+	name := filepath.Join(dir, baseName(chans[0].file)+"_rgb.png")
+	if err := savePNG(name, rgba); err != nil {
+		return err
+	}
+	log.Printf("exported %s\n", name)
+	return nil
+}
+
+func writePNG(name string, img *imageInfo, vmin, vmax float64, stretch Stretch, cmap *Colormap) error {
+	return savePNG(name, stretchImage(img.floatImage, vmin, vmax, stretch, cmap))
+}
+
+func savePNG(name string, m image.Image) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := png.Encode(f, m); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// exportName returns the PNG file name for the HDU hdu of the FITS file
+// fname, e.g. "ngc1316_1.png" for the first extension of ngc1316.fits.gz.
+func exportName(fname string, hdu int) string {
+	return fmt.Sprintf("%s_%d.png", baseName(fname), hdu)
+}
+
+// baseName returns the name of the FITS file fname without its directory
+// and its FITS and compression extensions.
+func baseName(fname string) string {
+	base := filepath.Base(fname)
+	for {
+		ext := strings.ToLower(filepath.Ext(base))
+		switch ext {
+		case ".fits", ".fit", ".fts", ".gz", ".bz2", ".fz":
+			base = strings.TrimSuffix(base, filepath.Ext(base))
+			continue
+		}
+		break
+	}
+	return base
+}
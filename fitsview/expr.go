@@ -0,0 +1,334 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// expr is an arithmetic expression of image variables, e.g. "(a-b)/c",
+// evaluated for each pixel.
+type expr struct {
+	text string
+	vars []string // variables, in order of first appearance
+	eval evalFunc
+}
+
+// evalFunc evaluates an expression for the values of its variables.
+type evalFunc func(vals []float64) float64
+
+// parseExpr parses an expression made of numbers, variables, the
+// operators + - * / and parentheses.
+func parseExpr(text string) (*expr, error) {
+	toks, err := tokenize(text)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{toks: toks, e: &expr{text: text}}
+	eval, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("unexpected %q in expression %q", p.toks[p.pos], text)
+	}
+	p.e.eval = eval
+	return p.e, nil
+}
+
+// tokenize splits an expression into numbers, names, operators and
+// parentheses.
+func tokenize(text string) ([]string, error) {
+	var toks []string
+	for i := 0; i < len(text); {
+		c := rune(text[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case strings.ContainsRune("+-*/()", c):
+			toks = append(toks, text[i:i+1])
+			i++
+		case unicode.IsDigit(c) || c == '.':
+			j := i
+			for j < len(text) && (unicode.IsDigit(rune(text[j])) || text[j] == '.' ||
+				text[j] == 'e' || text[j] == 'E' ||
+				((text[j] == '+' || text[j] == '-') && (text[j-1] == 'e' || text[j-1] == 'E'))) {
+				j++
+			}
+			toks = append(toks, text[i:j])
+			i = j
+		case unicode.IsLetter(c) || c == '_':
+			j := i
+			for j < len(text) && (unicode.IsLetter(rune(text[j])) || unicode.IsDigit(rune(text[j])) || text[j] == '_') {
+				j++
+			}
+			toks = append(toks, text[i:j])
+			i = j
+		default:
+			return nil, fmt.Errorf("invalid character %q in expression %q", c, text)
+		}
+	}
+	return toks, nil
+}
+
+// exprParser is a recursive descent parser of expressions, which
+// compiles them to closures.
+type exprParser struct {
+	toks []string
+	pos  int
+	e    *expr
+}
+
+func (p *exprParser) peek() string {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos]
+	}
+	return ""
+}
+
+// sum parses terms separated by + and -.
+func (p *exprParser) sum() (evalFunc, error) {
+	left, err := p.product()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.peek(); op == "+" || op == "-"; op = p.peek() {
+		p.pos++
+		right, err := p.product()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		if op == "+" {
+			left = func(v []float64) float64 { return l(v) + right(v) }
+		} else {
+			left = func(v []float64) float64 { return l(v) - right(v) }
+		}
+	}
+	return left, nil
+}
+
+// product parses factors separated by * and /.
+func (p *exprParser) product() (evalFunc, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.peek(); op == "*" || op == "/"; op = p.peek() {
+		p.pos++
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		if op == "*" {
+			left = func(v []float64) float64 { return l(v) * right(v) }
+		} else {
+			left = func(v []float64) float64 { return l(v) / right(v) }
+		}
+	}
+	return left, nil
+}
+
+// unary parses a factor with an optional sign.
+func (p *exprParser) unary() (evalFunc, error) {
+	switch p.peek() {
+	case "-":
+		p.pos++
+		f, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(v []float64) float64 { return -f(v) }, nil
+	case "+":
+		p.pos++
+		return p.unary()
+	}
+	return p.primary()
+}
+
+// primary parses a number, a variable or a parenthesized expression.
+func (p *exprParser) primary() (evalFunc, error) {
+	tok := p.peek()
+	p.pos++
+	switch {
+	case tok == "":
+		return nil, fmt.Errorf("unexpected end of expression %q", p.e.text)
+	case tok == "(":
+		f, err := p.sum()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("missing ) in expression %q", p.e.text)
+		}
+		p.pos++
+		return f, nil
+	case unicode.IsDigit(rune(tok[0])) || tok[0] == '.':
+		x, err := strconv.ParseFloat(tok, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q in expression %q", tok, p.e.text)
+		}
+		return func([]float64) float64 { return x }, nil
+	case unicode.IsLetter(rune(tok[0])) || tok[0] == '_':
+		k := len(p.e.vars)
+		for i, name := range p.e.vars {
+			if name == tok {
+				k = i
+			}
+		}
+		if k == len(p.e.vars) {
+			p.e.vars = append(p.e.vars, tok)
+		}
+		return func(v []float64) float64 { return v[k] }, nil
+	}
+	return nil, fmt.Errorf("unexpected %q in expression %q", tok, p.e.text)
+}
+
+// evalImages evaluates the expression for each pixel of the images of
+// its variables, given in the same order. Pixels which are blank in any
+// image, or whose result is not finite, are blank.
+func (e *expr) evalImages(imgs []*floatImage) (*floatImage, error) {
+	if len(imgs) != len(e.vars) {
+		return nil, fmt.Errorf("expression %q needs %d images, got %d", e.text, len(e.vars), len(imgs))
+	}
+	width, height := 1, 1
+	if len(imgs) > 0 {
+		width, height = imgs[0].Width, imgs[0].Height
+	}
+	for i, img := range imgs {
+		if img.Width != width || img.Height != height {
+			return nil, fmt.Errorf("image %s is %dx%d, want %dx%d", e.vars[i], img.Width, img.Height, width, height)
+		}
+	}
+
+	res := &floatImage{Data: make([]float64, width*height), Width: width, Height: height}
+	vals := make([]float64, len(imgs))
+	for i := range res.Data {
+		for k, img := range imgs {
+			vals[k] = img.Data[i]
+		}
+		v := e.eval(vals)
+		if math.IsInf(v, 0) {
+			v = math.NaN()
+		}
+		res.Data[i] = v
+	}
+	return res, nil
+}
+
+// imageExpr is an image computed from the image HDUs of its variables.
+type imageExpr struct {
+	*expr
+	operands []hduDesc // HDUs of the variables of the expression
+}
+
+// decode evaluates the expression on the first plane of the operands.
+// The result has the header and WCS of the first operand.
+func (ie *imageExpr) decode() (*hduData, error) {
+	imgs := make([]*floatImage, len(ie.operands))
+	var first *hduData
+	for i, desc := range ie.operands {
+		d, err := decodeHDU(desc)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			first = d
+		}
+		imgs[i] = d.floatImage
+	}
+	res, err := ie.evalImages(imgs)
+	if err != nil {
+		return nil, err
+	}
+	d := &hduData{floatImage: res}
+	if first != nil {
+		d.header, d.wcs = first.header, first.wcs
+	}
+	return d, nil
+}
+
+// exprName is the name of the file of the result of -expr.
+const exprName = "expr"
+
+// processExpr scans the operands of the expression text, given as
+// NAME=FILE or NAME=FILE[HDU]. It returns the result of the expression,
+// followed by the operands.
+func processExpr(text string, args []string) ([]fileInfo, error) {
+	e, err := parseExpr(text)
+	if err != nil {
+		return nil, err
+	}
+	specs := make(map[string]string)
+	for _, arg := range args {
+		i := strings.Index(arg, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid operand %q, want NAME=FILE", arg)
+		}
+		specs[arg[:i]] = arg[i+1:]
+	}
+	for name := range specs {
+		if !contains(e.vars, name) {
+			return nil, fmt.Errorf("operand %s is not in expression %q", name, text)
+		}
+	}
+
+	ie := &imageExpr{expr: e}
+	var operands []fileInfo
+	for _, name := range e.vars {
+		spec, ok := specs[name]
+		if !ok {
+			return nil, fmt.Errorf("no file for %s in expression %q", name, text)
+		}
+		desc, err := scanImage(spec)
+		if err != nil {
+			return nil, err
+		}
+		if len(ie.operands) > 0 && !sameAxes(desc.axes, ie.operands[0].axes) {
+			return nil, fmt.Errorf("%s=%s has shape %v, %s has shape %v",
+				name, spec, desc.axes, e.vars[0], ie.operands[0].axes)
+		}
+		ie.operands = append(ie.operands, desc)
+		operands = append(operands, fileInfo{Name: spec, Images: []imageInfo{{
+			hduDesc: desc,
+			scale:   100,
+			fit:     true,
+			qmin:    0.01,
+			qmax:    0.99,
+		}}})
+	}
+	if len(ie.operands) == 0 {
+		return nil, fmt.Errorf("no image in expression %q", text)
+	}
+
+	// The expression is not a file name, e.g. for -export.
+	result := fileInfo{Name: exprName, Images: []imageInfo{{
+		hduDesc: hduDesc{
+			file:   text,
+			axes:   ie.operands[0].axes[:2],
+			planes: 1,
+			expr:   ie,
+		},
+		scale: 100,
+		fit:   true,
+		qmin:  0.01,
+		qmax:  0.99,
+	}}}
+	return append([]fileInfo{result}, operands...), nil
+}
+
+// sameAxes reports whether two images have the same axes.
+func sameAxes(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/saimn/fitsio"
+)
+
+func TestParseExpr(t *testing.T) {
+	for _, table := range []struct {
+		text string
+		vars []string
+		vals []float64
+		want float64
+	}{
+		{"(a-b)/c", []string{"a", "b", "c"}, []float64{10, 4, 2}, 3},
+		{"a - b*2 + a", []string{"a", "b"}, []float64{1, 2}, -2},
+		{"-sci/flat_1", []string{"sci", "flat_1"}, []float64{6, 3}, -2},
+		{"1.5e1 - -a", []string{"a"}, []float64{1}, 16},
+		{"2", nil, nil, 2},
+	} {
+		e, err := parseExpr(table.text)
+		if err != nil {
+			t.Fatalf("parseExpr(%q): %v", table.text, err)
+		}
+		if !reflect.DeepEqual(e.vars, table.vars) {
+			t.Fatalf("parseExpr(%q): got vars=%v, want=%v", table.text, e.vars, table.vars)
+		}
+		if got := e.eval(table.vals); got != table.want {
+			t.Fatalf("parseExpr(%q): got=%v, want=%v", table.text, got, table.want)
+		}
+	}
+
+	for _, text := range []string{"", "a+", "(a-b", "a b", "a % b", "2e"} {
+		if _, err := parseExpr(text); err == nil {
+			t.Fatalf("parseExpr(%q): expected an error", text)
+		}
+	}
+}
+
+func TestEvalImages(t *testing.T) {
+	e, err := parseExpr("(a-b)/c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &floatImage{Data: []float64{10, 20, math.NaN(), 40}, Width: 2, Height: 2}
+	b := &floatImage{Data: []float64{2, 4, 6, 8}, Width: 2, Height: 2}
+	c := &floatImage{Data: []float64{2, 0, 1, 4}, Width: 2, Height: 2}
+	res, err := e.evalImages([]*floatImage{a, b, c})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Data[0] != 4 || !math.IsNaN(res.Data[1]) || !math.IsNaN(res.Data[2]) || res.Data[3] != 8 {
+		t.Fatalf("invalid result: %v", res.Data)
+	}
+
+	c.Width, c.Height = 4, 1
+	if _, err := e.evalImages([]*floatImage{a, b, c}); err == nil {
+		t.Fatalf("expected an error with images of different sizes")
+	}
+}
+
+func TestWriteFITS(t *testing.T) {
+	img := &floatImage{Data: []float64{1, math.NaN(), -2.5, 1e10, 0, 3}, Width: 3, Height: 2}
+	hdr := fitsio.NewHeader([]fitsio.Card{
+		{Name: "BITPIX", Value: 16},
+		{Name: "NAXIS", Value: 2},
+		{Name: "BSCALE", Value: 2.0},
+		{Name: "OBJECT", Value: "M31 'core'", Comment: "target"},
+		{Name: "CRVAL1", Value: 10.5},
+		{Name: "EXPTIME", Value: 300},
+		{Name: "ZCMPTYPE", Value: "RICE_1"},
+	}, fitsio.IMAGE_HDU, 16, []int{3, 2})
+
+	var buf bytes.Buffer
+	if err := writeFITS(&buf, img, hdr, "fitsview: a-b"); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 2*blockSize {
+		t.Fatalf("got size=%d, want=%d", buf.Len(), 2*blockSize)
+	}
+
+	text := buf.String()[:blockSize]
+	cards, _, err := readHeader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"SIMPLE": "T", "BITPIX": "-64", "NAXIS": "2", "NAXIS1": "3", "NAXIS2": "2",
+		"OBJECT": "M31 'core'", "CRVAL1": "10.5", "EXPTIME": "300",
+	}
+	if !reflect.DeepEqual(cards, want) {
+		t.Fatalf("got cards=%v, want=%v", cards, want)
+	}
+	if !strings.Contains(text, "HISTORY fitsview: a-b") {
+		t.Fatalf("missing HISTORY card in %q", text)
+	}
+
+	data := buf.Bytes()[blockSize:]
+	for i, v := range img.Data {
+		got := math.Float64frombits(binary.BigEndian.Uint64(data[i*8:]))
+		if got != v && !(math.IsNaN(got) && math.IsNaN(v)) {
+			t.Fatalf("pixel %d: got=%v, want=%v", i, got, v)
+		}
+	}
+}
+
+func TestFormatCards(t *testing.T) {
+	long := strings.Repeat("x", 75)
+	path := "fitsview: a=/data/" + strings.Repeat("night/", 10) + "sci.fits[1]"
+	for _, tc := range []struct {
+		card fitsio.Card
+		want []string
+	}{
+		{
+			fitsio.Card{Name: "OBJECT", Value: "M31", Comment: "target"},
+			[]string{"OBJECT  = 'M31     '           / target"},
+		},
+		{
+			fitsio.Card{Name: "OBJECT", Value: long, Comment: "target"},
+			[]string{
+				"OBJECT  = '" + long[:67] + "&'",
+				"CONTINUE  '" + long[67:] + "' / target",
+			},
+		},
+		{
+			// A doubled quote is not split.
+			fitsio.Card{Name: "OBJECT", Value: strings.Repeat("x", 66) + "'y"},
+			[]string{
+				"OBJECT  = '" + strings.Repeat("x", 66) + "&'",
+				"CONTINUE  '''y     '",
+			},
+		},
+		{
+			fitsio.Card{Name: "OBJECT", Value: strings.Repeat("x", 68), Comment: "no room"},
+			[]string{"OBJECT  = '" + strings.Repeat("x", 68) + "'"},
+		},
+		{
+			fitsio.Card{Name: "HISTORY", Value: path},
+			[]string{"HISTORY " + path[:72], "HISTORY " + path[72:]},
+		},
+	} {
+		got := formatCards(tc.card)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Fatalf("formatCards(%+v):\ngot =%q\nwant=%q", tc.card, got, tc.want)
+		}
+		for _, c := range got {
+			if len(c) > cardSize {
+				t.Fatalf("card longer than %d characters: %q", cardSize, c)
+			}
+		}
+	}
+}
+
+func TestProcessExpr(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, width, height int) string {
+		fname := filepath.Join(dir, name)
+		img := &floatImage{Data: make([]float64, width*height), Width: width, Height: height}
+		if err := saveFITS(fname, img, nil); err != nil {
+			t.Fatal(err)
+		}
+		return fname
+	}
+	sci, bias, small := write("sci.fits", 4, 3), write("bias.fits", 4, 3), write("small.fits", 2, 3)
+
+	infos, err := processExpr("a-b", []string{"a=" + sci, "b=" + bias})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 3 || infos[0].Name != "expr" || infos[1].Name != sci || infos[2].Name != bias {
+		t.Fatalf("invalid files: %+v", infos)
+	}
+	res := infos[0].Images[0]
+	if res.expr == nil || !reflect.DeepEqual(res.axes, []int{4, 3}) || len(res.expr.operands) != 2 {
+		t.Fatalf("invalid result: %+v", res.hduDesc)
+	}
+
+	for _, args := range [][]string{
+		{"a=" + sci, "b=" + small},
+		{"a=" + sci},
+		{"a=" + sci, "b=" + bias, "c=" + bias},
+		{"a=" + sci, bias},
+		{"a=" + sci, "b=" + filepath.Join(dir, "missing.fits")},
+	} {
+		if _, err := processExpr("a-b", args); err == nil {
+			t.Fatalf("processExpr(%v): expected an error", args)
+		}
+	}
+}
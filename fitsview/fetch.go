@@ -0,0 +1,290 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fetcher downloads remote FITS files. Downloads are stored in an
+// on-disk cache, named after the SHA-256 of their content, and are
+// revalidated with the server (ETag and Last-Modified) when reused.
+type fetcher struct {
+	client  *http.Client
+	dir     string // cache directory, no cache if empty
+	maxSize int64  // maximum size of the cached files, in bytes
+
+	mu    sync.Mutex
+	tmp   []string          // temporary files, removed by Cleanup
+	fresh map[string]string // hashes of the URLs already validated by Open
+}
+
+// cacheEntry describes the cached content of a URL.
+type cacheEntry struct {
+	URL          string `json:"url"`
+	Hash         string `json:"sha256"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+func newFetcher(dir string, timeout time.Duration, maxSize int64) *fetcher {
+	return &fetcher{
+		client:  &http.Client{Timeout: timeout},
+		dir:     dir,
+		maxSize: maxSize,
+		fresh:   make(map[string]string),
+	}
+}
+
+// defaultCacheDir returns the fitsview directory in the user cache
+// directory, or an empty string if there is none.
+func defaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "fitsview")
+}
+
+// Open returns the content of the URL. A URL is validated once per
+// session, later calls reuse the cached copy directly.
+func (f *fetcher) Open(url string) (io.ReadCloser, error) {
+	if f.dir == "" {
+		return f.download(url)
+	}
+
+	f.mu.Lock()
+	hash, ok := f.fresh[url]
+	f.mu.Unlock()
+	if ok {
+		if r, err := f.openObject(hash); err == nil {
+			return r, nil
+		}
+	}
+
+	entry, cached := f.lookup(url)
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if cached {
+		if entry.ETag != "" {
+			req.Header.Set("If-None-Match", entry.ETag)
+		}
+		if entry.LastModified != "" {
+			req.Header.Set("If-Modified-Since", entry.LastModified)
+		}
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		if cached {
+			log.Printf("could not revalidate %s, using the cached copy: %v\n", url, err)
+			f.markFresh(url, entry.Hash)
+			return f.openObject(entry.Hash)
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached:
+		f.markFresh(url, entry.Hash)
+		return f.openObject(entry.Hash)
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("could not download %s: %s", url, resp.Status)
+	}
+
+	hash, err = f.store(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	entry = cacheEntry{
+		URL:          url,
+		Hash:         hash,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+	if err := f.save(entry); err != nil {
+		return nil, err
+	}
+	f.markFresh(url, hash)
+
+	r, err := f.openObject(hash)
+	if err != nil {
+		return nil, err
+	}
+	f.evict(hash)
+	return r, nil
+}
+
+func (f *fetcher) markFresh(url, hash string) {
+	f.mu.Lock()
+	f.fresh[url] = hash
+	f.mu.Unlock()
+}
+
+// download copies the content of the URL to a temporary file, which is
+// removed when closed.
+func (f *fetcher) download(url string) (io.ReadCloser, error) {
+	resp, err := f.client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not download %s: %s", url, resp.Status)
+	}
+
+	tmp, err := f.tempFile("")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(tmp, resp.Body); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	return readCloser{tmp, func() error {
+		err := tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}}, nil
+}
+
+// tempFile creates a temporary file in dir, which is removed by Cleanup
+// if it still exists.
+func (f *fetcher) tempFile(dir string) (*os.File, error) {
+	tmp, err := ioutil.TempFile(dir, "view-fits-")
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	f.tmp = append(f.tmp, tmp.Name())
+	f.mu.Unlock()
+	return tmp, nil
+}
+
+// Cleanup removes the temporary files.
+func (f *fetcher) Cleanup() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, name := range f.tmp {
+		os.Remove(name)
+	}
+	f.tmp = nil
+}
+
+func (f *fetcher) objectPath(hash string) string {
+	return filepath.Join(f.dir, "objects", hash)
+}
+
+func (f *fetcher) entryPath(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(f.dir, "urls", hex.EncodeToString(sum[:])+".json")
+}
+
+// lookup returns the cache entry of the URL, if its content is cached.
+func (f *fetcher) lookup(url string) (cacheEntry, bool) {
+	var entry cacheEntry
+	buf, err := ioutil.ReadFile(f.entryPath(url))
+	if err != nil {
+		return entry, false
+	}
+	if err := json.Unmarshal(buf, &entry); err != nil || entry.URL != url {
+		return entry, false
+	}
+	if _, err := os.Stat(f.objectPath(entry.Hash)); err != nil {
+		return entry, false
+	}
+	return entry, true
+}
+
+func (f *fetcher) save(entry cacheEntry) error {
+	buf, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	name := f.entryPath(entry.URL)
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(name, buf, 0644)
+}
+
+// store copies r into the cache and returns the SHA-256 of its content.
+func (f *fetcher) store(r io.Reader) (string, error) {
+	dir := filepath.Join(f.dir, "objects")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	tmp, err := f.tempFile(dir)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, h), r); err != nil {
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	hash := hex.EncodeToString(h.Sum(nil))
+	if err := os.Rename(tmp.Name(), f.objectPath(hash)); err != nil {
+		return "", err
+	}
+	return hash, nil
+}
+
+// openObject opens a cached file and marks it as recently used.
+func (f *fetcher) openObject(hash string) (io.ReadCloser, error) {
+	name := f.objectPath(hash)
+	now := time.Now()
+	os.Chtimes(name, now, now)
+	return os.Open(name)
+}
+
+// evict removes the least recently used files until the cache is smaller
+// than maxSize. The file keep is never removed.
+func (f *fetcher) evict(keep string) {
+	files, err := ioutil.ReadDir(filepath.Join(f.dir, "objects"))
+	if err != nil {
+		return
+	}
+	var size int64
+	for _, fi := range files {
+		size += fi.Size()
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+	for _, fi := range files {
+		if size <= f.maxSize {
+			break
+		}
+		if fi.Name() == keep || strings.HasPrefix(fi.Name(), "view-fits-") {
+			continue
+		}
+		if err := os.Remove(f.objectPath(fi.Name())); err == nil {
+			size -= fi.Size()
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fitsServer serves a versioned content with an ETag.
+type fitsServer struct {
+	mu       sync.Mutex
+	version  int
+	requests int
+	hits     int // requests answered with 304 Not Modified
+}
+
+func (s *fitsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	etag := fmt.Sprintf(`"v%d"`, s.version)
+	if r.Header.Get("If-None-Match") == etag {
+		s.hits++
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("ETag", etag)
+	fmt.Fprintf(w, "%s%s version %d", r.URL.Path, strings.Repeat(" ", 100), s.version)
+}
+
+func readAll(t *testing.T, f *fetcher, url string) string {
+	r, err := f.Open(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf)
+}
+
+func TestFetcherCache(t *testing.T) {
+	srv := &fitsServer{}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "fitsview-cache-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f := newFetcher(dir, time.Second, 1<<20)
+	defer f.Cleanup()
+
+	first := readAll(t, f, ts.URL+"/a.fits")
+	if !strings.HasSuffix(first, "version 0") {
+		t.Fatalf("invalid content: %q", first)
+	}
+
+	// The URL is validated once per session.
+	if got := readAll(t, f, ts.URL+"/a.fits"); got != first {
+		t.Fatalf("invalid cached content: %q", got)
+	}
+	if srv.requests != 1 {
+		t.Fatalf("requests=%d, want 1", srv.requests)
+	}
+
+	// The download of a new session is revalidated with the ETag.
+	f = newFetcher(dir, time.Second, 1<<20)
+	defer f.Cleanup()
+	if got := readAll(t, f, ts.URL+"/a.fits"); got != first {
+		t.Fatalf("invalid cached content: %q", got)
+	}
+	if srv.requests != 2 || srv.hits != 1 {
+		t.Fatalf("requests=%d hits=%d, want 2 and 1", srv.requests, srv.hits)
+	}
+
+	// A new version is downloaded again.
+	srv.version++
+	f = newFetcher(dir, time.Second, 1<<20)
+	defer f.Cleanup()
+	if got := readAll(t, f, ts.URL+"/a.fits"); !strings.HasSuffix(got, "version 1") {
+		t.Fatalf("stale content: %q", got)
+	}
+	if srv.hits != 1 {
+		t.Fatalf("modified content served from the cache")
+	}
+
+	// The cached copy is used when the server is gone.
+	ts.Close()
+	f = newFetcher(dir, time.Second, 1<<20)
+	defer f.Cleanup()
+	if got := readAll(t, f, ts.URL+"/a.fits"); !strings.HasSuffix(got, "version 1") {
+		t.Fatalf("invalid offline content: %q", got)
+	}
+
+	tmp, _ := filepath.Glob(filepath.Join(dir, "objects", "view-fits-*"))
+	if len(tmp) != 0 {
+		t.Fatalf("temporary files left in the cache: %v", tmp)
+	}
+}
+
+func TestFetcherEviction(t *testing.T) {
+	ts := httptest.NewServer(&fitsServer{})
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "fitsview-cache-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Room for two files only.
+	f := newFetcher(dir, time.Second, 250)
+	defer f.Cleanup()
+	for _, name := range []string{"a", "b", "c"} {
+		readAll(t, f, ts.URL+"/"+name+".fits")
+		time.Sleep(10 * time.Millisecond)
+	}
+	objects, err := ioutil.ReadDir(filepath.Join(dir, "objects"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 2 {
+		t.Fatalf("got %d cached files, want 2", len(objects))
+	}
+	if _, ok := f.lookup(ts.URL + "/a.fits"); ok {
+		t.Fatalf("the least recently used file was not evicted")
+	}
+	if _, ok := f.lookup(ts.URL + "/c.fits"); !ok {
+		t.Fatalf("the last file was evicted")
+	}
+}
+
+func TestFetcherNoCache(t *testing.T) {
+	ts := httptest.NewServer(&fitsServer{})
+	defer ts.Close()
+
+	f := newFetcher("", time.Second, 0)
+	r, err := f.Open(ts.URL + "/a.fits")
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := f.tmp[0]
+	if _, err := os.Stat(name); err != nil {
+		t.Fatalf("temporary file is missing: %v", err)
+	}
+	r.Close()
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Fatalf("temporary file was not removed: %v", err)
+	}
+	f.Cleanup()
+}
+
+func TestFetcherTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer ts.Close()
+
+	f := newFetcher("", 50*time.Millisecond, 0)
+	defer f.Cleanup()
+	if _, err := f.Open(ts.URL + "/a.fits"); err == nil {
+		t.Fatalf("expected a timeout error")
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/saimn/fitsio"
+)
+
+// structuralKeywords matches the keywords describing the data array of
+// an image or a tile-compressed image, which are not copied to the
+// header of a new image.
+var structuralKeywords = regexp.MustCompile(`^(SIMPLE|XTENSION|BITPIX|NAXIS\d*|EXTEND|PCOUNT|GCOUNT|` +
+	`BSCALE|BZERO|BLANK|CHECKSUM|DATASUM|END|WCSAXES|TFIELDS|THEAP|T(TYPE|FORM|UNIT|DIM|NULL|SCAL|ZERO|DISP)\d+|` +
+	`Z(IMAGE|BITPIX|NAXIS\d*|TILE\d+|CMPTYPE|NAME\d+|VAL\d+|QUANTIZ|DITHER0|SIMPLE|EXTEND|BLOCKED|TENSION|` +
+	`PCOUNT|GCOUNT|HECKSUM|DATASUM|BLANK|SCALE|ZERO|MASK))$`)
+
+// writeFITS writes img as a FITS file of 64-bit floats. The cards of hdr
+// which do not describe the data array, e.g. the WCS, are copied, and
+// history is added as HISTORY cards.
+func writeFITS(w io.Writer, img *floatImage, hdr *fitsio.Header, history ...string) error {
+	var cards []string
+	for _, c := range []fitsio.Card{
+		{Name: "SIMPLE", Value: true, Comment: "conforms to FITS standard"},
+		{Name: "BITPIX", Value: -64, Comment: "64-bit floats"},
+		{Name: "NAXIS", Value: 2},
+		{Name: "NAXIS1", Value: img.Width},
+		{Name: "NAXIS2", Value: img.Height},
+	} {
+		cards = append(cards, formatCards(c)...)
+	}
+	if hdr != nil {
+		for i := range hdr.Keys() {
+			c := hdr.Card(i)
+			if structuralKeywords.MatchString(c.Name) {
+				continue
+			}
+			if v, ok := c.Value.(float64); ok && (math.IsNaN(v) || math.IsInf(v, 0)) {
+				continue
+			}
+			cards = append(cards, formatCards(*c)...)
+		}
+	}
+	for _, h := range history {
+		cards = append(cards, formatCards(fitsio.Card{Name: "HISTORY", Value: h})...)
+	}
+	cards = append(cards, "END")
+
+	bw := bufio.NewWriter(w)
+	n := 0
+	for _, c := range cards {
+		fmt.Fprintf(bw, "%-80s", c)
+		n += cardSize
+	}
+	bw.WriteString(strings.Repeat(" ", int(padBlock(int64(n))-int64(n))))
+
+	buf := make([]byte, 8)
+	for _, v := range img.Data {
+		binary.BigEndian.PutUint64(buf, math.Float64bits(v))
+		bw.Write(buf)
+	}
+	size := int64(len(img.Data) * 8)
+	bw.Write(make([]byte, padBlock(size)-size))
+	return bw.Flush()
+}
+
+// Longest text of a COMMENT or HISTORY card, and longest string in the
+// quotes of a card continued on the next one, followed by &.
+const (
+	commentarySize = cardSize - 8
+	continuedSize  = cardSize - 10 - 3
+)
+
+// formatCards formats a header card as one or more cards of 80
+// characters. The text of COMMENT and HISTORY cards is split over several
+// cards, and long strings are continued on CONTINUE cards. A comment is
+// truncated, or dropped if there is no room for it.
+func formatCards(c fitsio.Card) []string {
+	var s string
+	var cards []string
+	switch v := c.Value.(type) {
+	case nil:
+		s = c.Name
+	case string:
+		if c.Name == "COMMENT" || c.Name == "HISTORY" || c.Name == "" {
+			for len(v) > commentarySize {
+				cards = append(cards, fmt.Sprintf("%-8s%s", c.Name, v[:commentarySize]))
+				v = v[commentarySize:]
+			}
+			return append(cards, fmt.Sprintf("%-8s%s", c.Name, v))
+		}
+		parts := splitString(v)
+		for i, p := range parts[:len(parts)-1] {
+			if i == 0 {
+				cards = append(cards, fmt.Sprintf("%-8s= '%s&'", c.Name, p))
+			} else {
+				cards = append(cards, fmt.Sprintf("CONTINUE  '%s&'", p))
+			}
+		}
+		last := "'" + fmt.Sprintf("%-8s", parts[len(parts)-1]) + "'"
+		if len(parts) == 1 {
+			s = fmt.Sprintf("%-8s= %-20s", c.Name, last)
+		} else {
+			s = "CONTINUE  " + last
+		}
+	case float64:
+		f := strconv.FormatFloat(v, 'G', -1, 64)
+		if !strings.ContainsAny(f, ".E") {
+			f += ".0"
+		}
+		s = fmt.Sprintf("%-8s= %20s", c.Name, f)
+	default:
+		s = fmt.Sprintf("%-8s= %20s", c.Name, cardString(v))
+	}
+	if c.Comment != "" && c.Name != "COMMENT" && c.Name != "HISTORY" && len(s)+3 < cardSize {
+		s += " / " + c.Comment
+	}
+	if len(s) > cardSize {
+		// Only the comment is longer than a card.
+		s = s[:cardSize]
+	}
+	return append(cards, s)
+}
+
+// splitString returns the string v with its quotes doubled, split in
+// parts which fit in the quotes of a card. All the parts but the last
+// one are at most continuedSize long, a doubled quote is not split.
+func splitString(v string) []string {
+	var parts []string
+	var part strings.Builder
+	for i := 0; i < len(v); i++ {
+		c := v[i : i+1]
+		if c == "'" {
+			c = "''"
+		}
+		// The last part may use the room of the &.
+		if part.Len()+len(c) > continuedSize && (i < len(v)-1 || part.Len()+len(c) > continuedSize+1) {
+			parts = append(parts, part.String())
+			part.Reset()
+		}
+		part.WriteString(c)
+	}
+	return append(parts, part.String())
+}
+
+// saveFITS writes img to the FITS file name, see writeFITS.
+func saveFITS(name string, img *floatImage, hdr *fitsio.Header, history ...string) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := writeFITS(f, img, hdr, history...); err != nil {
+		return err
+	}
+	return f.Close()
+}
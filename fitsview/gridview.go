@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"log"
+	"runtime"
+
+	"github.com/gotk3/gotk3/cairo"
+	"github.com/gotk3/gotk3/gdk"
+	"github.com/gotk3/gotk3/glib"
+	"github.com/gotk3/gotk3/gtk"
+)
+
+// gridItem is an image shown in the thumbnail grid.
+type gridItem struct {
+	file, img int // position of the image in the files
+	label     string
+	job       thumbJob
+}
+
+// gridView is the window showing the thumbnails of all the images.
+type gridView struct {
+	*gtk.Window
+	area  *gtk.DrawingArea
+	label *gtk.Label
+
+	items   []gridItem
+	current int // item of the current image, -1 if none
+	cols    int // columns of the last drawn grid
+	height  int // height of the last drawn grid
+	opts    thumbOptions
+
+	thumbs  map[hduKey]*gdk.Pixbuf
+	pending map[hduKey]bool // thumbnails being computed
+	stop    chan struct{}   // closed to stop computing the thumbnails
+	gen     int             // incremented when the thumbnails are reset
+
+	// onSelect is called with the image clicked by the user.
+	onSelect func(file, img int)
+}
+
+func gridWindow(parent *gtk.ApplicationWindow) *gridView {
+	win, err := gtk.WindowNew(gtk.WINDOW_TOPLEVEL)
+	if err != nil {
+		log.Fatal("Unable to create window:", err)
+	}
+	win.SetTitle("Thumbnails")
+	win.SetTransientFor(parent)
+	win.SetDefaultSize(800, 600)
+	win.HideOnDelete()
+
+	vbox, _ := gtk.BoxNew(gtk.ORIENTATION_VERTICAL, 0)
+	win.Add(vbox)
+
+	scroll, err := gtk.ScrolledWindowNew(nil, nil)
+	if err != nil {
+		log.Fatal("Unable to create scrolled window:", err)
+	}
+	scroll.SetPolicy(gtk.POLICY_NEVER, gtk.POLICY_AUTOMATIC)
+	vbox.PackStart(scroll, true, true, 0)
+
+	area, err := gtk.DrawingAreaNew()
+	if err != nil {
+		log.Fatal("Unable to create drawing area:", err)
+	}
+	area.AddEvents(int(gdk.BUTTON_PRESS_MASK | gdk.POINTER_MOTION_MASK))
+	scroll.Add(area)
+
+	label, err := gtk.LabelNew("")
+	if err != nil {
+		log.Fatal("Unable to create label:", err)
+	}
+	label.SetXAlign(0)
+	vbox.PackStart(label, false, false, 5)
+
+	v := &gridView{
+		Window:  win,
+		area:    area,
+		label:   label,
+		current: -1,
+		thumbs:  make(map[hduKey]*gdk.Pixbuf),
+		pending: make(map[hduKey]bool),
+		stop:    make(chan struct{}),
+	}
+	area.Connect("draw", v.draw)
+	area.Connect("button-press-event", func(da *gtk.DrawingArea, ev *gdk.Event) bool {
+		btn := gdk.EventButtonNewFromEvent(ev)
+		i := v.itemAt(btn.X(), btn.Y())
+		if btn.Button() != gdk.BUTTON_PRIMARY || i < 0 || v.onSelect == nil {
+			return false
+		}
+		v.onSelect(v.items[i].file, v.items[i].img)
+		return true
+	})
+	area.Connect("motion-notify-event", func(da *gtk.DrawingArea, ev *gdk.Event) bool {
+		x, y := gdk.EventMotionNewFromEvent(ev).MotionVal()
+		if i := v.itemAt(x, y); i >= 0 {
+			v.label.SetText(v.items[i].label)
+		} else {
+			v.showCurrent()
+		}
+		return false
+	})
+	vbox.ShowAll()
+	return v
+}
+
+// setImages shows the thumbnails of the images of infos, the image of
+// cursor c being highlighted. The missing thumbnails are computed in the
+// background.
+func (v *gridView) setImages(infos []fileInfo, c cursor, opts thumbOptions) {
+	if opts != v.opts {
+		// The thumbnails are rendered again with the new options.
+		close(v.stop)
+		v.stop = make(chan struct{})
+		v.thumbs = make(map[hduKey]*gdk.Pixbuf)
+		v.pending = make(map[hduKey]bool)
+		v.opts = opts
+		v.gen++
+	}
+
+	v.items, v.current = nil, -1
+	var jobs []thumbJob
+	for i, finfo := range infos {
+		for j, img := range finfo.Images {
+			if i == c.file && j == c.img {
+				v.current = len(v.items)
+			}
+			job := thumbJob{img.hduDesc, img.qmin, img.qmax}
+			label := finfo.Name
+			if len(finfo.Images) > 1 {
+				label = fmt.Sprintf("%s[%d]", finfo.Name, img.hdu)
+			}
+			v.items = append(v.items, gridItem{file: i, img: j, label: label, job: job})
+			if key := img.key(); v.thumbs[key] == nil && !v.pending[key] {
+				v.pending[key] = true
+				jobs = append(jobs, job)
+			}
+		}
+	}
+	v.SetTitle(fmt.Sprintf("Thumbnails of %d images", len(v.items)))
+	v.showCurrent()
+	v.area.QueueDraw()
+	if len(jobs) == 0 {
+		return
+	}
+
+	stop, gen := v.stop, v.gen
+	go runPool(len(jobs), runtime.NumCPU(), stop, func(i int) {
+		m, err := makeThumbnail(jobs[i], opts)
+		glib.IdleAdd(func() bool {
+			v.addThumb(jobs[i].desc.key(), m, err, gen)
+			return false
+		})
+	})
+}
+
+// addThumb shows the thumbnail m of the image key, computed for the
+// generation gen of the thumbnails.
+func (v *gridView) addThumb(key hduKey, m *image.RGBA, err error, gen int) {
+	if gen != v.gen {
+		return
+	}
+	delete(v.pending, key)
+	if err != nil {
+		log.Printf("could not make the thumbnail of %s[%d]: %v\n", key.file, key.hdu, err)
+		return
+	}
+	pixbuf, err := pixBufFromRGBA(m)
+	if err != nil {
+		log.Printf("could not create pixbuf: %v\n", err)
+		return
+	}
+	v.thumbs[key] = pixbuf
+	v.area.QueueDraw()
+}
+
+func (v *gridView) showCurrent() {
+	if v.current >= 0 {
+		v.label.SetText(v.items[v.current].label)
+	} else {
+		v.label.SetText("")
+	}
+}
+
+// itemAt returns the item at the position x, y of the drawing area, -1
+// if none.
+func (v *gridView) itemAt(x, y float64) int {
+	if v.cols == 0 {
+		return -1
+	}
+	return cellAt(image.Pt(int(x), int(y)), v.cols, len(v.items), v.opts.size)
+}
+
+func (v *gridView) draw(da *gtk.DrawingArea, cr *cairo.Context) {
+	size := v.opts.size
+	if size <= 0 {
+		return
+	}
+	cols := (da.GetAllocatedWidth() - thumbMargin) / (size + thumbMargin)
+	if cols < 1 {
+		cols = 1
+	}
+	v.cols = cols
+	// The height of the area follows the number of rows.
+	rows := (len(v.items) + cols - 1) / cols
+	if height := rows*(size+thumbMargin) + thumbMargin; height != v.height {
+		v.height = height
+		da.SetSizeRequest(-1, height)
+	}
+
+	cr.SetSourceRGB(0.16, 0.16, 0.16)
+	cr.Paint()
+	for i, item := range v.items {
+		o := cellOrigin(i, cols, size)
+		x, y := float64(o.X), float64(o.Y)
+		if pixbuf := v.thumbs[item.job.desc.key()]; pixbuf != nil {
+			w, h := pixbuf.GetWidth(), pixbuf.GetHeight()
+			px, py := x+float64((size-w)/2), y+float64((size-h)/2)
+			gdk.CairoSetSourcePixbuf(cr, pixbuf, px, py)
+			cr.Rectangle(px, py, float64(w), float64(h))
+			cr.Fill()
+		} else {
+			// Not computed yet.
+			cr.SetSourceRGB(0.25, 0.25, 0.25)
+			cr.Rectangle(x, y, float64(size), float64(size))
+			cr.Fill()
+		}
+		if i == v.current {
+			cr.SetSourceRGB(1, 0.3, 0.3)
+			cr.SetLineWidth(3)
+			cr.Rectangle(x-2, y-2, float64(size+4), float64(size+4))
+			cr.Stroke()
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/gotk3/gotk3/glib"
+	"github.com/gotk3/gotk3/gtk"
+	"github.com/saimn/fitsio"
+)
+
+// usedKeywords matches the keywords read by the viewer: data format,
+// tile compression and WCS.
+var usedKeywords = regexp.MustCompile(`^(BITPIX|NAXIS\d*|BSCALE|BZERO|BLANK|` +
+	`Z(IMAGE|BITPIX|NAXIS\d*|TILE\d+|CMPTYPE|NAME\d+|VAL\d+|QUANTIZ|DITHER0|SCALE|ZERO|BLANK)|` +
+	`CTYPE\d|CUNIT\d|CRPIX\d|CRVAL\d|CDELT\d|CROTA2|CD\d_\d|PC\d_\d)$`)
+
+// headerRow is a card shown in the header panel.
+type headerRow struct {
+	name, value, comment string
+	used                 bool // keyword used by the viewer
+}
+
+// headerRows returns the cards of hdr containing search in their name,
+// value or comment, ignoring case.
+func headerRows(hdr *fitsio.Header, search string) []headerRow {
+	if hdr == nil {
+		return nil
+	}
+	search = strings.ToLower(search)
+	var rows []headerRow
+	for i := range hdr.Keys() {
+		card := hdr.Card(i)
+		row := headerRow{
+			name:    card.Name,
+			value:   cardString(card.Value),
+			comment: card.Comment,
+			used:    usedKeywords.MatchString(card.Name),
+		}
+		text := strings.ToLower(row.name + "\x00" + row.value + "\x00" + row.comment)
+		if search == "" || strings.Contains(text, search) {
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// cardString formats the value of a card as written in headers.
+func cardString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case bool:
+		if v {
+			return "T"
+		}
+		return "F"
+	case string:
+		return v
+	}
+	return fmt.Sprint(v)
+}
+
+// headerView holds the widgets of the header panel.
+type headerView struct {
+	*gtk.Box
+	search *gtk.SearchEntry
+	store  *gtk.ListStore
+	header *fitsio.Header
+}
+
+// Columns of the header panel store.
+const (
+	colName = iota
+	colValue
+	colComment
+	colWeight
+)
+
+const weightNormal, weightBold = 400, 700
+
+func headerPanel() *headerView {
+	vbox, _ := gtk.BoxNew(gtk.ORIENTATION_VERTICAL, 0)
+	vbox.SetSizeRequest(450, -1)
+
+	search, err := gtk.SearchEntryNew()
+	if err != nil {
+		log.Fatal("Unable to create search entry:", err)
+	}
+	vbox.PackStart(search, false, false, 5)
+
+	store, err := gtk.ListStoreNew(glib.TYPE_STRING, glib.TYPE_STRING, glib.TYPE_STRING, glib.TYPE_INT)
+	if err != nil {
+		log.Fatal("Unable to create list store:", err)
+	}
+	tree, err := gtk.TreeViewNewWithModel(store)
+	if err != nil {
+		log.Fatal("Unable to create tree view:", err)
+	}
+	tree.SetEnableSearch(false)
+	for _, c := range []struct {
+		title string
+		col   int
+	}{{"Name", colName}, {"Value", colValue}, {"Comment", colComment}} {
+		renderer, err := gtk.CellRendererTextNew()
+		if err != nil {
+			log.Fatal("Unable to create cell renderer:", err)
+		}
+		column, err := gtk.TreeViewColumnNewWithAttribute(c.title, renderer, "text", c.col)
+		if err != nil {
+			log.Fatal("Unable to create tree view column:", err)
+		}
+		column.AddAttribute(renderer, "weight", colWeight)
+		column.SetResizable(true)
+		tree.AppendColumn(column)
+	}
+
+	scroll, err := gtk.ScrolledWindowNew(nil, nil)
+	if err != nil {
+		log.Fatal("Unable to create scrolled window:", err)
+	}
+	scroll.SetPolicy(gtk.POLICY_AUTOMATIC, gtk.POLICY_AUTOMATIC)
+	scroll.Add(tree)
+	vbox.PackStart(scroll, true, true, 0)
+
+	v := &headerView{Box: vbox, search: search, store: store}
+	search.Connect("search-changed", func() {
+		v.refresh()
+	})
+	vbox.SetNoShowAll(true)
+	return v
+}
+
+// setHeader shows the cards of hdr, which may be nil.
+func (v *headerView) setHeader(hdr *fitsio.Header) {
+	v.header = hdr
+	if v.GetVisible() {
+		v.refresh()
+	}
+}
+
+// toggle shows or hides the panel.
+func (v *headerView) toggle() {
+	if v.GetVisible() {
+		v.Hide()
+		return
+	}
+	v.refresh()
+	v.SetNoShowAll(false)
+	v.ShowAll()
+	v.search.GrabFocus()
+}
+
+func (v *headerView) refresh() {
+	search, _ := v.search.GetText()
+	v.store.Clear()
+	for _, row := range headerRows(v.header, search) {
+		weight := weightNormal
+		if row.used {
+			weight = weightBold
+		}
+		err := v.store.Set(v.store.Append(),
+			[]int{colName, colValue, colComment, colWeight},
+			[]interface{}{row.name, row.value, row.comment, weight})
+		if err != nil {
+			log.Printf("could not add card %s: %v\n", row.name, err)
+		}
+	}
+}
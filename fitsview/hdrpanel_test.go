@@ -0,0 +1,41 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/saimn/fitsio"
+)
+
+func TestHeaderRows(t *testing.T) {
+	hdr := fitsio.NewHeader([]fitsio.Card{
+		{Name: "BITPIX", Value: -32, Comment: "number of bits per pixel"},
+		{Name: "NAXIS1", Value: 100},
+		{Name: "OBJECT", Value: "NGC 1316", Comment: "target"},
+		{Name: "CD1_2", Value: 0.0},
+		{Name: "SIMPLE", Value: true},
+		{Name: "EXPTIME", Value: 300.5, Comment: "exposure time"},
+	}, fitsio.IMAGE_HDU, -32, []int{100})
+
+	for _, table := range []struct {
+		search string
+		want   []headerRow
+	}{
+		{"ngc", []headerRow{{"OBJECT", "NGC 1316", "target", false}}},
+		{"TIME", []headerRow{{"EXPTIME", "300.5", "exposure time", false}}},
+		{"naxis", []headerRow{{"NAXIS1", "100", "", true}}},
+		{"bits", []headerRow{{"BITPIX", "-32", "number of bits per pixel", true}}},
+		{"cd1", []headerRow{{"CD1_2", "0", "", true}}},
+		{"simple", []headerRow{{"SIMPLE", "T", "", false}}},
+		{"nothing", nil},
+	} {
+		got := headerRows(hdr, table.search)
+		if !reflect.DeepEqual(got, table.want) {
+			t.Fatalf("search %q\ngot =%v\nwant=%v\n", table.search, got, table.want)
+		}
+	}
+
+	if got := len(headerRows(hdr, "")); got != 6 {
+		t.Fatalf("got %d cards, want 6", got)
+	}
+}
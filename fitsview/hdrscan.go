@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+const (
+	blockSize = 2880 // size of a FITS block
+	cardSize  = 80   // size of a header card
+)
+
+// hduDesc describes an image HDU found by scanning the headers of a
+// file, its data are not read.
+type hduDesc struct {
+	file   string
+	hdu    int   // index of the HDU in the file
+	offset int64 // offset of the header in the (uncompressed) file
+	size   int64 // size of the header and the data, without padding
+	axes   []int // image axes, the uncompressed ones for tile-compressed images
+	planes int   // number of planes of a data cube, 1 for an image
+
+	expr *imageExpr // expression computing the image, nil for an HDU of a file
+}
+
+// hduKey identifies an HDU among all the files.
+type hduKey struct {
+	file string
+	hdu  int
+}
+
+func (d hduDesc) key() hduKey {
+	return hduKey{d.file, d.hdu}
+}
+
+// scanHDUs reads the headers of the FITS stream r and returns the HDUs
+// holding an image or a tile-compressed image. Data arrays are skipped.
+func scanHDUs(r io.Reader, file string) ([]hduDesc, error) {
+	images, _, err := scanFITS(r, file)
+	return images, err
+}
+
+// scanFITS reads the headers of the FITS stream r and returns the HDUs
+// holding an image or a tile-compressed image, and the HDUs holding a
+// binary or ASCII table. The axes of a table are the size of a row in
+// bytes and the number of rows. Data arrays are skipped.
+func scanFITS(r io.Reader, file string) (images, tables []hduDesc, err error) {
+	var offset int64
+	for i := 0; ; i++ {
+		cards, hsize, err := readHeader(r)
+		if err == io.EOF && i > 0 {
+			return images, tables, nil
+		}
+		if err != nil {
+			return images, tables, fmt.Errorf("%s[%d]: %v", file, i, err)
+		}
+
+		dsize := dataSize(cards)
+		if err := skip(r, dsize); err != nil {
+			return images, tables, fmt.Errorf("%s[%d]: %v", file, i, err)
+		}
+		// The padding of the last data array is sometimes missing.
+		skip(r, padBlock(dsize)-dsize)
+
+		desc := hduDesc{
+			file:   file,
+			hdu:    i,
+			offset: offset,
+			size:   hsize + dsize,
+		}
+		if axes := imageAxes(cards); len(axes) >= 2 {
+			desc.axes, desc.planes = axes, 1
+			for _, n := range axes[2:] {
+				desc.planes *= n
+			}
+			images = append(images, desc)
+		} else if isTable(cards) {
+			desc.axes = []int{cardInt(cards, "NAXIS1", 0), cardInt(cards, "NAXIS2", 0)}
+			tables = append(tables, desc)
+		}
+		offset += hsize + padBlock(dsize)
+	}
+}
+
+// isTable reports whether the HDU is a binary or ASCII table, other than
+// a tile-compressed image.
+func isTable(cards map[string]string) bool {
+	switch cards["XTENSION"] {
+	case "BINTABLE":
+		return cards["ZIMAGE"] != "T"
+	case "TABLE":
+		return true
+	}
+	return false
+}
+
+// readHeader reads the cards of a header up to the END card, values are
+// indexed by keyword. It returns the size of the header in bytes.
+func readHeader(r io.Reader) (map[string]string, int64, error) {
+	cards := make(map[string]string)
+	block := make([]byte, blockSize)
+	var size int64
+	for {
+		if _, err := io.ReadFull(r, block); err != nil {
+			if err == io.ErrUnexpectedEOF || (err == io.EOF && size > 0) {
+				err = fmt.Errorf("truncated header")
+			}
+			return nil, size, err
+		}
+		if size == 0 && !bytes.HasPrefix(block, []byte("SIMPLE  ")) && !bytes.HasPrefix(block, []byte("XTENSION")) {
+			return nil, size, fmt.Errorf("not a FITS header")
+		}
+		size += blockSize
+		for i := 0; i < blockSize; i += cardSize {
+			card := string(block[i : i+cardSize])
+			key := strings.TrimSpace(card[:8])
+			if key == "END" {
+				return cards, size, nil
+			}
+			if card[8:10] == "= " {
+				cards[key] = cardValue(card[10:])
+			}
+		}
+	}
+}
+
+// cardValue returns the value of a card without its comment, string
+// values are unquoted.
+func cardValue(s string) string {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "'") {
+		var v strings.Builder
+		for i := 1; i < len(s); i++ {
+			if s[i] == '\'' {
+				if i+1 < len(s) && s[i+1] == '\'' {
+					v.WriteByte('\'')
+					i++
+					continue
+				}
+				break
+			}
+			v.WriteByte(s[i])
+		}
+		return strings.TrimRight(v.String(), " ")
+	}
+	if i := strings.Index(s, "/"); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s)
+}
+
+func cardInt(cards map[string]string, key string, def int) int {
+	v, ok := cards[key]
+	if !ok {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return def
+	}
+	return n
+}
+
+// dataSize returns the size of the data array described by the header,
+// without padding.
+func dataSize(cards map[string]string) int64 {
+	naxis := cardInt(cards, "NAXIS", 0)
+	if naxis == 0 {
+		return 0
+	}
+	n := int64(1)
+	for i := 1; i <= naxis; i++ {
+		n *= int64(cardInt(cards, fmt.Sprintf("NAXIS%d", i), 0))
+	}
+	bitpix := cardInt(cards, "BITPIX", 8)
+	if bitpix < 0 {
+		bitpix = -bitpix
+	}
+	pcount := int64(cardInt(cards, "PCOUNT", 0))
+	gcount := int64(cardInt(cards, "GCOUNT", 1))
+	return int64(bitpix/8) * gcount * (pcount + n)
+}
+
+// imageAxes returns the axes of the image stored in the HDU, nil when the
+// HDU is not an image or a tile-compressed image.
+func imageAxes(cards map[string]string) []int {
+	prefix := "NAXIS"
+	switch cards["XTENSION"] {
+	case "", "IMAGE":
+	case "BINTABLE":
+		if cards["ZIMAGE"] != "T" {
+			return nil
+		}
+		prefix = "ZNAXIS"
+	default:
+		return nil
+	}
+	naxis := cardInt(cards, prefix, 0)
+	if naxis <= 0 {
+		return nil
+	}
+	axes := make([]int, naxis)
+	for i := range axes {
+		axes[i] = cardInt(cards, fmt.Sprintf("%s%d", prefix, i+1), 0)
+	}
+	return axes
+}
+
+func padBlock(n int64) int64 {
+	return (n + blockSize - 1) / blockSize * blockSize
+}
+
+// skip discards n bytes of r, seeking when r supports it.
+func skip(r io.Reader, n int64) error {
+	if n == 0 {
+		return nil
+	}
+	if s, ok := r.(io.Seeker); ok {
+		_, err := s.Seek(n, io.SeekCurrent)
+		return err
+	}
+	m, err := io.CopyN(ioutil.Discard, r, n)
+	if m < n && err == io.EOF {
+		err = fmt.Errorf("truncated data array")
+	}
+	return err
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fitsHeader returns a header made of the given cards, padded to a block.
+func fitsHeader(cards ...string) []byte {
+	var b strings.Builder
+	for _, c := range append(cards, "END") {
+		fmt.Fprintf(&b, "%-80s", c)
+	}
+	return []byte(b.String() + strings.Repeat(" ", int(padBlock(int64(b.Len()))-int64(b.Len()))))
+}
+
+func TestScanHDUs(t *testing.T) {
+	var file bytes.Buffer
+	file.Write(fitsHeader("SIMPLE  = T", "BITPIX  = 8", "NAXIS   = 0", "EXTEND  = T"))
+
+	// A 2x3x4 cube of 16-bit integers, the data fill one block.
+	file.Write(fitsHeader("XTENSION= 'IMAGE   '", "BITPIX  = 16", "NAXIS   = 3",
+		"NAXIS1  = 2", "NAXIS2  = 3", "NAXIS3  = 4", "PCOUNT  = 0", "GCOUNT  = 1"))
+	file.Write(make([]byte, blockSize))
+
+	// A table with 2 rows of 3000 bytes.
+	file.Write(fitsHeader("XTENSION= 'BINTABLE'", "BITPIX  = 8", "NAXIS   = 2",
+		"NAXIS1  = 3000", "NAXIS2  = 2", "PCOUNT  = 0", "GCOUNT  = 1", "TFIELDS = 1"))
+	file.Write(make([]byte, 3*blockSize))
+
+	// A tile-compressed image with its heap, the last block is not padded.
+	file.Write(fitsHeader("XTENSION= 'BINTABLE'", "BITPIX  = 8", "NAXIS   = 2",
+		"NAXIS1  = 8", "NAXIS2  = 10", "PCOUNT  = 100", "GCOUNT  = 1",
+		"ZIMAGE  = T / tile-compressed image", "ZNAXIS  = 2", "ZNAXIS1 = 100", "ZNAXIS2 = 10"))
+	file.Write(make([]byte, 180))
+
+	want := []hduDesc{
+		{file: "a.fits", hdu: 1, offset: blockSize, size: blockSize + 24*2, axes: []int{2, 3, 4}, planes: 4},
+		{file: "a.fits", hdu: 3, offset: 7 * blockSize, size: blockSize + 180, axes: []int{100, 10}, planes: 1},
+	}
+
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(file.Bytes())
+	zw.Close()
+
+	for _, data := range [][]byte{file.Bytes(), gz.Bytes()} {
+		r, err := decompress(ioutil.NopCloser(bytes.NewReader(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := scanHDUs(r, "a.fits")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("invalid descriptors\ngot =%+v\nwant=%+v\n", got, want)
+		}
+	}
+
+	_, tables, err := scanFITS(bytes.NewReader(file.Bytes()), "a.fits")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantTables := []hduDesc{{file: "a.fits", hdu: 2, offset: 3 * blockSize, size: blockSize + 6000, axes: []int{3000, 2}}}
+	if !reflect.DeepEqual(tables, wantTables) {
+		t.Fatalf("invalid tables\ngot =%+v\nwant=%+v\n", tables, wantTables)
+	}
+
+	if _, err := scanHDUs(bytes.NewReader(make([]byte, blockSize)), "b.fits"); err == nil {
+		t.Fatalf("expected an error for a file which is not FITS")
+	}
+}
+
+func TestCardValue(t *testing.T) {
+	for _, table := range []struct {
+		card string
+		want string
+	}{
+		{"                   16 / number of bits", "16"},
+		{"'IMAGE   '           / extension", "IMAGE"},
+		{"'it''s a / test'", "it's a / test"},
+		{"T", "T"},
+	} {
+		if got := cardValue(table.card); got != table.want {
+			t.Fatalf("cardValue(%q): got=%q, want=%q", table.card, got, table.want)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/saimn/fitsio"
+	"github.com/saimn/margo/fitsview/wcs"
+)
+
+// hduData holds the decoded data of an image HDU.
+type hduData struct {
+	*floatImage
+	header *fitsio.Header
+
+	pixels []float64 // sorted pixel values, see sortedPixels
+
+	wcs *wcs.WCS // celestial WCS, nil if the header has none
+
+	plane int        // index of the decoded plane
+	raw   []byte     // raw data array of a data cube
+	df    dataFormat // data type of raw
+	spec  *wcs.Axis  // world coordinate of the third axis, if any
+}
+
+// decodeHDU reads and decodes the image HDU described by desc.
+func decodeHDU(desc hduDesc) (*hduData, error) {
+	if desc.expr != nil {
+		return desc.expr.decode()
+	}
+	f, hdu, err := openHDU(desc)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	d, err := newHDUData(hdu)
+	if err != nil {
+		return nil, fmt.Errorf("%s[%d]: %v", desc.file, desc.hdu, err)
+	}
+	return d, nil
+}
+
+// openHDU reads the HDU described by desc. The returned file must be
+// closed once the HDU is decoded.
+func openHDU(desc hduDesc) (*fitsio.File, fitsio.HDU, error) {
+	r, err := openStream(desc.file)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer r.Close()
+
+	if err := skip(r, desc.offset); err != nil {
+		return nil, nil, err
+	}
+	buf := make([]byte, padBlock(desc.size))
+	if _, err := io.ReadFull(r, buf[:desc.size]); err != nil {
+		return nil, nil, fmt.Errorf("%s[%d]: %v", desc.file, desc.hdu, err)
+	}
+	if desc.hdu > 0 {
+		// fitsio only reads whole files, the extension is given an
+		// empty primary HDU.
+		buf = append(emptyPrimary(), buf...)
+	}
+
+	f, err := fitsio.Open(bytes.NewReader(buf))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s[%d]: %v", desc.file, desc.hdu, err)
+	}
+	hdus := f.HDUs()
+	if len(hdus) == 0 {
+		f.Close()
+		return nil, nil, fmt.Errorf("%s[%d]: no HDU", desc.file, desc.hdu)
+	}
+	return f, hdus[len(hdus)-1], nil
+}
+
+// newHDUData decodes the first plane of an image or tile-compressed
+// image HDU.
+func newHDUData(hdu fitsio.HDU) (*hduData, error) {
+	header := hdu.Header()
+	axes := header.Axes()
+
+	var raw []byte
+	var df dataFormat
+	switch hdu := hdu.(type) {
+	case fitsio.Image:
+		raw, df = hdu.Raw(), newDataFormat(header)
+	case *fitsio.Table:
+		// Tile-compressed images are stored in binary tables.
+		if !isTileCompressed(header) {
+			return nil, fmt.Errorf("not an image")
+		}
+		var err error
+		raw, axes, df, err = uncompressImage(hdu)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("not an image")
+	}
+
+	if len(axes) < 2 {
+		return nil, fmt.Errorf("not an image")
+	}
+	img, err := decodeImage(raw, axes[0], axes[1], df)
+	if err != nil {
+		return nil, err
+	}
+	w, err := wcs.New(header)
+	if err != nil && err != wcs.ErrNoWCS {
+		log.Printf("invalid WCS: %v\n", err)
+	}
+	d := &hduData{
+		floatImage: img,
+		header:     header,
+		wcs:        w,
+	}
+	if len(axes) > 2 {
+		// Keep the raw cube, planes are decoded when displayed.
+		d.raw = raw
+		d.df = df
+		d.spec, _ = wcs.NewAxis(header, 3)
+	}
+	return d, nil
+}
+
+// emptyPrimary returns a primary header with no data.
+func emptyPrimary() []byte {
+	cards := []string{
+		"SIMPLE  =                    T",
+		"BITPIX  =                    8",
+		"NAXIS   =                    0",
+		"EXTEND  =                    T",
+		"END",
+	}
+	var b strings.Builder
+	for _, c := range cards {
+		fmt.Fprintf(&b, "%-80s", c)
+	}
+	return []byte(fmt.Sprintf("%-2880s", b.String()))
+}
+
+// imageCache keeps the most recently used decoded images in memory.
+// Images can be decoded in the background by prefetch, they are attached
+// to their imageInfo by load, from the GTK main loop only.
+type imageCache struct {
+	decode func(hduDesc) (*hduData, error)
+	max    int // maximum number of decoded images
+
+	mu      sync.Mutex
+	entries map[hduKey]*cacheItem
+	lru     *list.List  // keys, the most recently used first
+	evicted []cacheItem // evicted images still attached to an imageInfo
+}
+
+type cacheItem struct {
+	done  chan struct{} // closed once decoded
+	data  *hduData
+	err   error
+	owner *imageInfo // image the data are attached to, if any
+	elem  *list.Element
+}
+
+func newImageCache(max int) *imageCache {
+	if max < 1 {
+		max = 1
+	}
+	return &imageCache{
+		decode:  decodeHDU,
+		max:     max,
+		entries: make(map[hduKey]*cacheItem),
+		lru:     list.New(),
+	}
+}
+
+// get returns the decoded data of the HDU, decoding them if they are not
+// in the cache. Concurrent calls for the same HDU decode it once.
+func (c *imageCache) get(desc hduDesc) (*hduData, error) {
+	key := desc.key()
+
+	c.mu.Lock()
+	if it, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(it.elem)
+		c.mu.Unlock()
+		<-it.done
+		return it.data, it.err
+	}
+	it := &cacheItem{done: make(chan struct{})}
+	it.elem = c.lru.PushFront(key)
+	c.entries[key] = it
+	c.mu.Unlock()
+
+	it.data, it.err = c.decode(desc)
+	close(it.done)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if it.err != nil {
+		// Failures are not cached, the HDU is decoded again next time.
+		c.remove(key, it)
+	}
+	c.evict()
+	return it.data, it.err
+}
+
+// load attaches the decoded data to img, and detaches them from the
+// images evicted from the cache.
+func (c *imageCache) load(img *imageInfo) error {
+	if img.hduData != nil {
+		c.keep(img)
+	} else {
+		d, err := c.get(img.hduDesc)
+		if err != nil {
+			return err
+		}
+		img.hduData = d
+	}
+
+	c.mu.Lock()
+	if it, ok := c.entries[img.key()]; ok && it.data == img.hduData {
+		it.owner = img
+	}
+	evicted := c.evicted
+	c.evicted = nil
+	c.mu.Unlock()
+
+	for _, it := range evicted {
+		if it.owner != img && it.owner.hduData == it.data {
+			it.owner.hduData = nil
+		}
+	}
+	return nil
+}
+
+// keep marks the data attached to img as the most recently used, they are
+// put back in the cache if they were evicted.
+func (c *imageCache) keep(img *imageInfo) {
+	key := img.key()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if it, ok := c.entries[key]; ok {
+		if it.data == img.hduData {
+			c.lru.MoveToFront(it.elem)
+			return
+		}
+		c.remove(key, it)
+	}
+	it := &cacheItem{done: make(chan struct{}), data: img.hduData, owner: img}
+	close(it.done)
+	it.elem = c.lru.PushFront(key)
+	c.entries[key] = it
+	c.evict()
+}
+
+// prefetch decodes the HDUs in the background.
+func (c *imageCache) prefetch(descs ...hduDesc) {
+	go func() {
+		for _, desc := range descs {
+			if _, err := c.get(desc); err != nil {
+				log.Printf("could not prefetch %s[%d]: %v\n", desc.file, desc.hdu, err)
+			}
+		}
+	}()
+}
+
+// evict removes the least recently used images beyond max. The caller
+// must hold c.mu.
+func (c *imageCache) evict() {
+	for c.lru.Len() > c.max {
+		key := c.lru.Back().Value.(hduKey)
+		it := c.entries[key]
+		c.remove(key, it)
+		if it.owner != nil {
+			c.evicted = append(c.evicted, *it)
+		}
+	}
+}
+
+// remove deletes the item of key, if it is still it. The caller must hold
+// c.mu.
+func (c *imageCache) remove(key hduKey, it *cacheItem) {
+	if c.entries[key] != it {
+		return
+	}
+	c.lru.Remove(it.elem)
+	delete(c.entries, key)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestImageCache(t *testing.T) {
+	var mu sync.Mutex
+	decoded := make(map[int]int)
+
+	c := newImageCache(2)
+	c.decode = func(desc hduDesc) (*hduData, error) {
+		mu.Lock()
+		decoded[desc.hdu]++
+		mu.Unlock()
+		if desc.hdu < 0 {
+			return nil, fmt.Errorf("invalid HDU")
+		}
+		return &hduData{floatImage: &floatImage{Width: 1, Height: 1, Data: []float64{float64(desc.hdu)}}}, nil
+	}
+
+	imgs := make([]imageInfo, 3)
+	for i := range imgs {
+		imgs[i].hduDesc = hduDesc{file: "a.fits", hdu: i, axes: []int{1, 1}, planes: 1}
+	}
+
+	for _, i := range []int{0, 1, 0, 2} {
+		if err := c.load(&imgs[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The least recently used image is released.
+	if imgs[1].hduData != nil || imgs[0].hduData == nil || imgs[2].hduData == nil {
+		t.Fatalf("image 1 was not evicted")
+	}
+	if decoded[0] != 1 || decoded[1] != 1 || decoded[2] != 1 {
+		t.Fatalf("images decoded several times: %v", decoded)
+	}
+
+	// Concurrent requests decode an HDU once.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.get(imgs[1].hduDesc)
+		}()
+	}
+	wg.Wait()
+	if decoded[1] != 2 {
+		t.Fatalf("image 1 decoded %d times, want 2", decoded[1])
+	}
+
+	// Failures are not cached.
+	bad := imageInfo{hduDesc: hduDesc{file: "a.fits", hdu: -1}}
+	for i := 0; i < 2; i++ {
+		if err := c.load(&bad); err == nil {
+			t.Fatalf("expected an error")
+		}
+	}
+	if decoded[-1] != 2 {
+		t.Fatalf("failed image decoded %d times, want 2", decoded[-1])
+	}
+}
@@ -1,21 +1,24 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"image"
 	"io"
-	"io/ioutil"
+	"io/fs"
 	"log"
-	"net/http"
+	"math"
 	"os"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/gotk3/gotk3/cairo"
 	"github.com/gotk3/gotk3/gdk"
 	"github.com/gotk3/gotk3/glib"
 	"github.com/gotk3/gotk3/gtk"
-	"github.com/saimn/fitsio"
-	"gonum.org/v1/gonum/floats"
+	"github.com/saimn/margo/fitsview/wcs"
 	"gonum.org/v1/gonum/stat"
 )
 
@@ -24,22 +27,47 @@ const maxUint32 = 65535
 type fileInfo struct {
 	Name   string
 	Images []imageInfo
+	Tables []hduDesc // binary and ASCII tables
 }
 
 type imageInfo struct {
-	image.Image
-	scale int // image scale in percents (default: 100%)
-	orig  image.Point
+	hduDesc
+	*hduData // decoded data, nil until loaded by the image cache
+
+	scale int         // image scale in percents (default: 100%)
+	orig  image.Point // image pixel at the top left corner of the window
+	fit   bool        // fit the image to the window (default: true)
+
+	qmin, qmax float64 // display quantiles (default: 0.01 and 0.99)
+}
+
+// Bounds returns the bounds of the image, which are known before its
+// data are decoded.
+func (img *imageInfo) Bounds() image.Rectangle {
+	return image.Rect(0, 0, img.axes[0], img.axes[1])
+}
+
+// sortedPixels returns the sorted pixel values of the image. They are
+// computed on the first call and cached, so that changing the display
+// quantiles does not sort the pixels again.
+func (img *imageInfo) sortedPixels() []float64 {
+	if img.pixels == nil {
+		img.pixels, _ = getPixels(img.floatImage)
+		sort.Float64s(img.pixels)
+	}
+	return img.pixels
 }
 
 type cursor struct {
-	file int
-	img  int
+	file  int
+	img   int
+	plane int
 }
 
 func (cur *cursor) Next(nbFiles int) {
 	cur.file = (cur.file + 1) % nbFiles
 	cur.img = 0
+	cur.plane = 0
 }
 
 func (cur *cursor) Prev(nbFiles int) {
@@ -48,10 +76,65 @@ func (cur *cursor) Prev(nbFiles int) {
 		cur.file = nbFiles + cur.file
 	}
 	cur.img = 0
+	cur.plane = 0
 }
 
-// Current displayed file and image in file.
-var cur = cursor{file: 0, img: 0}
+// Current displayed file, image in file and plane in data cube.
+var cur = cursor{file: 0, img: 0, plane: 0}
+
+var (
+	exportDir   = flag.String("export", "", "export the stretched images as PNG files to `DIR` and exit")
+	sheetFile   = flag.String("contact-sheet", "", "write the thumbnails of all the images as one PNG `FILE` and exit")
+	thumbSize   = flag.Int("thumb-size", 128, "size of the thumbnails of the grid and of the contact sheet, in pixels")
+	stretchName = flag.String("stretch", "linear", "stretch function (linear, log, sqrt, squared, asinh, histeq)")
+	cmapName    = flag.String("cmap", "gray", "colormap (gray, gray-inverted, viridis, inferno, magma, heat, cool)")
+	resampling  = flag.String("resample", "nearest", "resampling method when zooming (nearest, bilinear)")
+	timeout     = flag.Duration("timeout", time.Minute, "timeout of the downloads of remote files")
+	cacheSize   = flag.Int64("cache-size", 1024, "maximum size of the cache of remote files, in `MB` (0 disables the cache)")
+	maxImages   = flag.Int("max-images", 8, "maximum number of decoded images kept in memory")
+	blinkDelay  = flag.Duration("blink", 500*time.Millisecond, "interval between the files of the blink mode")
+	alignWCS    = flag.Bool("align", false, "align the compared images on their WCS (toggle with a)")
+	rgbSpec     = flag.String("rgb", "", "combine three images `R,G,B` into a colour composite, each given as FILE or FILE[HDU]")
+	rgbWeights  = flag.String("rgb-weights", "1,1,1", "weights of the red, green and blue channels")
+	rgbMode     = flag.String("rgb-mode", "channel", "combination of the RGB channels (channel, lupton)")
+	luptonQ     = flag.Float64("lupton-q", 8, "softening parameter of the lupton RGB mode")
+	regionFile  = flag.String("regions", "", "DS9 region `FILE` shown over the images, where ctrl+s saves the regions (default fitsview.reg)")
+	starRadius  = flag.Int("star-radius", 10, "radius of the star profiles, in pixels")
+	watchPath   = flag.String("watch", "", "show the FITS files of `DIR`, and the new ones as they arrive (pause with w)")
+	pollDelay   = flag.Duration("poll", 2*time.Second, "interval between the polls of the watched directory, when inotify is not available")
+	streamPath  = flag.String("stream", "", "show the consecutive FITS files read from `FILE`, a named pipe or - for the standard input, as they arrive (pause with w)")
+	streamKeep  = flag.Int("stream-keep", 100, "number of the last frames of -stream kept in memory, the older ones can not be shown anymore")
+	sessionFile = flag.String("session", "", "restore the files, the views and the regions from the session `FILE`, saved on quit")
+	exprText    = flag.String("expr", "", "show the image computed by the `EXPRESSION` of images given as NAME=FILE or NAME=FILE[HDU], e.g. \"(a-b)/c\"")
+	exprOut     = flag.String("expr-out", "fitsview_expr.fits", "FITS `FILE` where ctrl+e saves the result of -expr")
+	catalogSpec = flag.String("catalog", "", "draw the sources of the catalogue `FILE`, a CSV file or a FITS table given as FILE or FILE[HDU] (toggle with k)")
+	catalogRA   = flag.String("catalog-ra", "", "`COLUMN` of the right ascension of the sources, in degrees or sexagesimal (default RA, RAJ2000, ...)")
+	catalogDec  = flag.String("catalog-dec", "", "`COLUMN` of the declination of the sources, in degrees or sexagesimal (default DEC, DEJ2000, ...)")
+	catalogLab  = flag.String("catalog-label", "", "`COLUMN` of the labels of the sources")
+	keysFile    = flag.String("keys", "", "key bindings `FILE`, with lines ACTION = KEY[, KEY...] (default keys.conf in the fitsview config directory)")
+)
+
+// Downloader of remote files.
+var remote *fetcher
+
+// Decoded images.
+var images *imageCache
+
+// Regions drawn over the images.
+var regions []region
+
+// Keys of the actions.
+var bindings = defaultBindings()
+
+// Catalogue of sources drawn over the images, nil if none.
+var sources *catalog
+
+// New files of the watched directory or of the stream, nil if none is
+// watched.
+var watched <-chan string
+
+// Session restored from the session file, nil if none.
+var restored *session
 
 func main() {
 	if len(os.Args) < 2 {
@@ -60,6 +143,162 @@ func main() {
 	log.SetFlags(0)
 	log.SetPrefix("[view-fits] ")
 
+	flag.Parse()
+	if *sessionFile != "" {
+		if *rgbSpec != "" {
+			log.Fatal("A session can not be used with -rgb")
+		}
+		s, err := loadSession(*sessionFile)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			// New session, saved on quit.
+		case err != nil:
+			log.Fatalf("Could not read session file %s: %v", *sessionFile, err)
+		default:
+			restored = s
+			// The options given on the command line override the session.
+			set := make(map[string]bool)
+			flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+			if !set["stretch"] && s.Stretch != "" {
+				*stretchName = s.Stretch
+			}
+			if !set["cmap"] && s.Colormap != "" {
+				*cmapName = s.Colormap
+			}
+		}
+	}
+	if !isStretch(*stretchName) {
+		log.Fatalf("Unknown stretch %q", *stretchName)
+	}
+	if _, err := lookupColormap(*cmapName); err != nil {
+		log.Fatal(err)
+	}
+	if !contains(resampleMethods, *resampling) {
+		log.Fatalf("Unknown resampling method %q", *resampling)
+	}
+	if *blinkDelay <= 0 {
+		log.Fatalf("Invalid blink interval %v", *blinkDelay)
+	}
+	if _, err := parseWeights(*rgbWeights); err != nil {
+		log.Fatal(err)
+	}
+	if !contains(rgbModes, *rgbMode) {
+		log.Fatalf("Unknown RGB mode %q", *rgbMode)
+	}
+	if *starRadius <= 0 {
+		log.Fatalf("Invalid star radius %d", *starRadius)
+	}
+	if *thumbSize <= 0 {
+		log.Fatalf("Invalid thumbnail size %d", *thumbSize)
+	}
+	if *rgbSpec != "" && flag.NArg() > 0 {
+		log.Fatal("Input files can not be given with -rgb")
+	}
+	if *rgbSpec != "" && *watchPath != "" {
+		log.Fatal("A directory can not be watched with -rgb")
+	}
+	if *exprText != "" && (*rgbSpec != "" || *watchPath != "" || *sessionFile != "") {
+		log.Fatal("-expr can not be used with -rgb, -watch or -session")
+	}
+	if *pollDelay <= 0 {
+		log.Fatalf("Invalid poll interval %v", *pollDelay)
+	}
+	if *streamPath != "" && (*rgbSpec != "" || *watchPath != "" || *exprText != "") {
+		log.Fatal("-stream can not be used with -rgb, -watch or -expr")
+	}
+	if *streamPath == "-" && contains(flag.Args(), "-") {
+		log.Fatal("The standard input can not be both a file and a stream")
+	}
+	if *streamKeep <= 0 {
+		log.Fatalf("Invalid number of frames kept %d", *streamKeep)
+	}
+	frames.max = *streamKeep
+	if name := *keysFile; name != "" || defaultBindingsFile() != "" {
+		if name == "" {
+			name = defaultBindingsFile()
+		}
+		user, err := loadBindings(name)
+		switch {
+		case errors.Is(err, fs.ErrNotExist) && *keysFile == "":
+			// No key bindings file, the default keys are used.
+		case err != nil:
+			log.Fatal("Could not read key bindings:", err)
+		default:
+			bindings.override(user)
+		}
+	}
+
+	cacheDir := defaultCacheDir()
+	if *cacheSize <= 0 {
+		cacheDir = ""
+	}
+	remote = newFetcher(cacheDir, *timeout, *cacheSize<<20)
+	if *rgbSpec != "" && *maxImages < 3 {
+		// The three channels are decoded together.
+		*maxImages = 3
+	}
+	images = newImageCache(*maxImages)
+
+	if *regionFile != "" {
+		f, err := os.Open(*regionFile)
+		if err != nil {
+			log.Fatal("Could not open region file:", err)
+		}
+		regions, err = parseRegions(f)
+		f.Close()
+		if err != nil {
+			log.Fatalf("Could not read region file %s: %v", *regionFile, err)
+		}
+	} else if restored != nil {
+		var err error
+		regions, err = restored.regionList()
+		if err != nil {
+			log.Fatalf("Could not read the regions of session %s: %v", *sessionFile, err)
+		}
+	}
+
+	if *catalogSpec != "" {
+		c, err := loadCatalog(*catalogSpec, *catalogRA, *catalogDec, *catalogLab)
+		if err != nil {
+			log.Fatal("Could not read catalogue:", err)
+		}
+		sources = c
+	}
+
+	if *exportDir != "" {
+		err := exportImages(inputFiles(), *exportDir)
+		remote.Cleanup()
+		if err != nil {
+			log.Fatal("Could not export images:", err)
+		}
+		return
+	}
+	if *sheetFile != "" {
+		err := exportContactSheet(inputFiles(), *sheetFile, *thumbSize)
+		remote.Cleanup()
+		if err != nil {
+			log.Fatal("Could not export contact sheet:", err)
+		}
+		return
+	}
+
+	if *watchPath != "" {
+		files, err := watchDir(*watchPath, *pollDelay)
+		if err != nil {
+			log.Fatal("Could not watch directory:", err)
+		}
+		watched = files
+		waitFirstFile(files, *watchPath)
+	}
+	if *streamPath != "" {
+		files, err := streamFrames(*streamPath)
+		if err != nil {
+			log.Fatal("Could not read stream:", err)
+		}
+		watched = files
+		waitFirstFile(files, *streamPath)
+	}
+
 	const appID = "com.github.saimn.fitsview"
 	application, err := gtk.ApplicationNew(appID, glib.APPLICATION_HANDLES_COMMAND_LINE)
 	if err != nil {
@@ -68,13 +307,13 @@ func main() {
 
 	application.Connect("command-line", func() int {
 		flag.Parse()
-		fmt.Printf("Args: %v\n", flag.Args())
+		log.Printf("args: %v\n", flag.Args())
 		application.Activate()
 		return 0
 	})
 
 	application.Connect("activate", func() {
-		win := newWindow(application)
+		win, files := newWindow(application)
 
 		// aNew := glib.SimpleActionNew("new", nil)
 		// aNew.Connect("activate", func() {
@@ -82,23 +321,52 @@ func main() {
 		// })
 		// application.AddAction(aNew)
 
+		saveCurrentSession := func() {
+			if *sessionFile == "" {
+				return
+			}
+			s, err := newSession(files(), cur, *stretchName, *cmapName, regions)
+			if err == nil {
+				err = saveSession(*sessionFile, s)
+			}
+			if err != nil {
+				log.Printf("could not save session: %v\n", err)
+			}
+		}
 		aQuit := glib.SimpleActionNew("quit", nil)
 		aQuit.Connect("activate", func() {
+			saveCurrentSession()
 			application.Quit()
 		})
 		application.AddAction(aQuit)
+		// Closing the window from its title bar does not run app.quit.
+		win.Connect("delete-event", func() bool {
+			saveCurrentSession()
+			return false
+		})
 
 		win.ShowAll()
 	})
 
-	os.Exit(application.Run(os.Args))
+	status := application.Run(os.Args)
+	remote.Cleanup()
+	os.Exit(status)
 }
 
-func newWindow(application *gtk.Application) *gtk.ApplicationWindow {
-	infos := processFiles()
+// newWindow creates the main window, and returns it with a function
+// returning the files it shows. The main window is the table browser
+// when no file has an image.
+func newWindow(application *gtk.Application) (*gtk.ApplicationWindow, func() []fileInfo) {
+	infos, tableFiles := splitTables(inputFiles())
 	nbFiles := len(infos)
 	if len(infos) == 0 {
-		log.Fatal("No image among given FITS files.")
+		if len(tableFiles) == 0 {
+			log.Fatal("No image nor table among given FITS files.")
+		}
+		return newTablesWindow(application, tableFiles)
+	}
+	if restored != nil {
+		cur = restored.apply(infos)
 	}
 
 	win, err := gtk.ApplicationWindowNew(application)
@@ -131,9 +399,7 @@ func newWindow(application *gtk.Application) *gtk.ApplicationWindow {
 	// Other prefixes can be added to widgets via InsertActionGroup
 	// menu.Append("New Window", "app.new")
 	// menu.Append("Close Window", "win.close")
-	menu.Append("Next file [right]", "custom.nextfile")
-	menu.Append("Prev file [left]", "custom.prevfile")
-	menu.Append("Quit", "app.quit")
+	// The actions of the viewer are added to the menu with their keys.
 
 	// Create the action "win.close"
 	aClose := glib.SimpleActionNew("close", nil)
@@ -154,85 +420,928 @@ func newWindow(application *gtk.Application) *gtk.ApplicationWindow {
 	vbox, _ := gtk.BoxNew(gtk.ORIENTATION_VERTICAL, 0)
 	win.Add(vbox)
 
-	imageWidget, err := gtk.ImageNew()
-	vbox.Add(imageWidget)
-	// win.SetDefault(imageWidget)
+	area, err := gtk.DrawingAreaNew()
+	if err != nil {
+		log.Fatal("Unable to create drawing area:", err)
+	}
+	area.SetSizeRequest(200, 200)
+	area.SetCanFocus(true)
+	area.AddEvents(int(gdk.BUTTON_PRESS_MASK | gdk.BUTTON_RELEASE_MASK |
+		gdk.POINTER_MOTION_MASK | gdk.SCROLL_MASK))
+	// The header panel is shown on the right of the image.
+	hbox, _ := gtk.BoxNew(gtk.ORIENTATION_HORIZONTAL, 0)
+	hbox.PackStart(area, true, true, 0)
+
+	// Reference image of the split view, on the right of the image.
+	area2, err := gtk.DrawingAreaNew()
+	if err != nil {
+		log.Fatal("Unable to create drawing area:", err)
+	}
+	area2.SetSizeRequest(200, 200)
+	area2.SetNoShowAll(true)
+	hbox.PackStart(area2, true, true, 2)
+	panel := headerPanel()
+	hbox.PackEnd(panel.Box, false, false, 0)
+	vbox.PackStart(hbox, true, true, 0)
+
+	// Plane slider, only shown for data cubes.
+	planeScale, err := gtk.ScaleNewWithRange(gtk.ORIENTATION_HORIZONTAL, 1, 2, 1)
+	if err != nil {
+		log.Fatal("Unable to create scale:", err)
+	}
+	planeScale.SetDigits(0)
+	planeScale.SetNoShowAll(true)
+	vbox.PackStart(planeScale, false, false, 5)
+
+	footer := footerBar()
+	vbox.PackStart(footer.Box, false, false, 5)
+
+	status, err := gtk.LabelNew("")
+	if err != nil {
+		log.Fatal("Unable to create label:", err)
+	}
+	status.SetXAlign(0)
+	vbox.PackStart(status, false, false, 5)
+
+	current := func() *imageInfo {
+		return &infos[cur.file].Images[cur.img]
+	}
+
+	// Stretched image at full resolution, resampled by the draw handler.
+	var rendered *image.RGBA
+
+	// Shape drawn with the mouse, the mouse pans the image if empty.
+	regionShape := ""
+	// Image positions of the region being drawn.
+	var draft []fpoint
+	// Index of the selected region, -1 if none.
+	selected := -1
+	// Measurement tool used with the mouse: "line" for a line profile,
+	// "star" for a radial profile, or empty.
+	measure := ""
+	plot := plotWindow(win)
+	tables := tableWindow(win, plot)
+	// allFiles returns the files with images, then the files with only
+	// tables.
+	allFiles := func() []fileInfo {
+		return append(append([]fileInfo(nil), infos...), tableFiles...)
+	}
+	tables.setFiles(allFiles())
+	grid := gridWindow(win)
+
+	// Show the sources of the catalogue.
+	showSources := sources != nil
+	// Index of the selected source, -1 if none.
+	source := -1
+	sourceWin := sourceWindow(win)
+
+	// Files shown by the blink mode, sorted.
+	var marked []int
+	// Reference file shown on the right of the split view, -1 if none.
+	split := -1
+	var rendered2 *image.RGBA
+
+	// Show the RGB composite of the channels, or the current channel.
+	showRGB := *rgbSpec != ""
+
+	// Statistics of the selected region, or of the image.
+	statsWin := statsWindow(win)
+	updateStats := func() {
+		img := current()
+		if !statsWin.GetVisible() || img.hduData == nil {
+			return
+		}
+		pixels := img.sortedPixels()
+		if len(pixels) == 0 {
+			return
+		}
+		vmin, vmax, _ := computeQuantiles(img, img.qmin, img.qmax)
+		if selected >= 0 && selected < len(regions) && regions[selected].shape != "point" {
+			values, nans := regionPixels(img, regions[selected])
+			title := fmt.Sprintf("%s region %d", regions[selected].shape, selected+1)
+			statsWin.update(title, values, nans, vmin, vmax)
+			return
+		}
+		statsWin.update(infos[cur.file].Name, pixels, len(img.Data)-len(pixels), vmin, vmax)
+	}
 
-	vbox.PackStart(footerBar(), false, false, 5)
+	// updateGrid shows the thumbnails of the files, with the current
+	// stretch and colormap.
+	updateGrid := func() {
+		cmap, err := lookupColormap(*cmapName)
+		if err != nil {
+			log.Printf("could not render thumbnails: %v\n", err)
+			return
+		}
+		grid.setImages(infos, cur, thumbOptions{size: *thumbSize, stretch: *stretchName, cmap: cmap})
+	}
+
+	// updatingPlane is set while drawImage moves the plane slider.
+	updatingPlane := false
 
 	drawImage := func(i int) {
 		log.Printf("file: %v\n", infos[i].Name)
 		log.Printf("ext : %d/%d\n", cur.img+1, len(infos[i].Images))
-		header.SetSubtitle(infos[i].Name)
 		img := &infos[i].Images[cur.img]
-		qmin, qmax := computeQuantiles(img, 0.01, 0.99)
-		pixbuf, _ := pixBufFromImage(img.Image, qmin, qmax)
-		imageWidget.SetFromPixbuf(pixbuf)
+		if tables.GetVisible() {
+			tables.setFile(infos[i])
+		}
+		if grid.GetVisible() {
+			updateGrid()
+		}
+		if err := images.load(img); err != nil {
+			log.Printf("could not read image: %v\n", err)
+			panel.setHeader(nil)
+			rendered = nil
+			area.QueueDraw()
+			return
+		}
+		prefetch(infos, i)
+		panel.setHeader(img.header)
+		if err := img.setPlane(cur.plane); err != nil {
+			log.Printf("could not read plane: %v\n", err)
+			cur.plane = img.plane
+		}
+		name := infos[i].Name
+		if isMarked(marked, i) {
+			name += " (marked)"
+		}
+		subtitle := fmt.Sprintf("%s [%s, %s]", name, *stretchName, *cmapName)
+		if showRGB {
+			subtitle = fmt.Sprintf("RGB composite of %s [%s, %s]", *rgbSpec, *rgbMode, *stretchName)
+		}
+		if label := img.planeLabel(); label != "" {
+			subtitle += " " + label
+		}
+		header.SetSubtitle(subtitle)
+
+		updatingPlane = true
+		if img.planes > 1 {
+			planeScale.SetRange(1, float64(img.planes))
+			planeScale.SetValue(float64(img.plane + 1))
+			planeScale.Show()
+		} else {
+			planeScale.Hide()
+		}
+		updatingPlane = false
+
+		footer.setQuantiles(img.qmin, img.qmax)
+		qmin, qmax, err := computeQuantiles(img, img.qmin, img.qmax)
+		if err != nil && !showRGB {
+			// There is nothing to stretch, the image is transparent.
+			status.SetText(fmt.Sprintf("%s[%d]: %v", infos[i].Name, img.hdu, err))
+			rendered, rendered2 = image.NewRGBA(img.Bounds()), nil
+			footer.cbar.SetVisible(false)
+			area.QueueDraw()
+			area2.QueueDraw()
+			updateStats()
+			return
+		}
+		// The channel shown may be blank in the RGB composite, which has
+		// no colorbar.
+		var stretch Stretch
+		if err == nil {
+			stretch, err = newStretch(*stretchName, img.sortedPixels(), qmin, qmax)
+			if err != nil {
+				log.Printf("invalid stretch: %v\n", err)
+				return
+			}
+		}
+		cmap, err := lookupColormap(*cmapName)
+		if err != nil {
+			log.Printf("invalid colormap: %v\n", err)
+			return
+		}
+		if showRGB {
+			chans, err := loadChannels(infos)
+			if err == nil {
+				rendered, err = composeRGB(chans, rgbFlags(img.qmin, img.qmax))
+			}
+			if err != nil {
+				log.Printf("could not compose the RGB image: %v\n", err)
+				rendered = nil
+			}
+		} else {
+			rendered = stretchImage(img.floatImage, qmin, qmax, stretch, cmap)
+		}
+		area.QueueDraw()
+		if stretch != nil {
+			footer.setColorbar(cmap, stretch, qmin, qmax)
+		}
+		footer.cbar.SetVisible(!showRGB)
+		updateStats()
+
+		if split >= 0 && stretch != nil {
+			// The reference image is shown with the same display limits.
+			ref := &infos[split].Images[0]
+			rendered2 = nil
+			if err := images.load(ref); err != nil {
+				log.Printf("could not read reference image: %v\n", err)
+			} else if stretch, err := newStretch(*stretchName, ref.sortedPixels(), qmin, qmax); err == nil {
+				rendered2 = stretchImage(ref.floatImage, qmin, qmax, stretch, cmap)
+			}
+			area2.QueueDraw()
+		}
 	}
 	drawImage(cur.file)
 
-	// Create an action in the custom action group
-	aNextFile := glib.SimpleActionNew("nextfile", nil)
-	aNextFile.Connect("activate", func() {
-		cur.Next(nbFiles)
+	// paint draws rgba, the stretched image img, and the regions in da.
+	paint := func(da *gtk.DrawingArea, cr *cairo.Context, img *imageInfo, rgba *image.RGBA) *overlay {
+		width, height := da.GetAllocatedWidth(), da.GetAllocatedHeight()
+		view, err := resample(rgba, width, height, img.scale, img.orig, *resampling)
+		if err != nil {
+			log.Printf("could not resample image: %v\n", err)
+			return nil
+		}
+		pixbuf, err := pixBufFromRGBA(view)
+		if err != nil {
+			log.Printf("could not create pixbuf: %v\n", err)
+			return nil
+		}
+		gdk.CairoSetSourcePixbuf(cr, pixbuf, 0, 0)
+		cr.Paint()
+
+		o := newOverlay(cr, img)
+		cr.SetLineWidth(1.5)
+		for i, reg := range regions {
+			if i == selected {
+				cr.SetSourceRGB(1, 0.3, 0.3)
+			} else {
+				cr.SetSourceRGB(0.3, 1, 0.3)
+			}
+			o.region(reg)
+		}
+		if showSources && sources != nil {
+			o.sources(sources, source)
+		}
+		return o
+	}
+
+	area.Connect("draw", func(da *gtk.DrawingArea, cr *cairo.Context) {
+		if rendered == nil {
+			return
+		}
+		img := current()
+		if img.fit {
+			img.fitToWindow(da.GetAllocatedWidth(), da.GetAllocatedHeight())
+		}
+		o := paint(da, cr, img, rendered)
+		if o != nil && len(draft) > 0 {
+			// Region being drawn with the mouse.
+			cr.SetSourceRGB(1, 1, 0.3)
+			if regionShape == "polygon" || measure == "line" {
+				o.polygon(draft, false)
+			} else {
+				o.region(newRegion(regionShape, draft, nil))
+			}
+		}
+		if split >= 0 {
+			// The split view follows the zoom and pan of the image.
+			area2.QueueDraw()
+		}
+	})
+
+	area2.Connect("draw", func(da *gtk.DrawingArea, cr *cairo.Context) {
+		if split < 0 || rendered2 == nil {
+			return
+		}
+		ref := &infos[split].Images[0]
+		if ref.hduData == nil {
+			return
+		}
+		alignView(ref, current(), area.GetAllocatedWidth(), area.GetAllocatedHeight(), *alignWCS)
+		paint(da, cr, ref, rendered2)
+	})
+
+	zoom := func(scale int) {
+		width, height := area.GetAllocatedWidth(), area.GetAllocatedHeight()
+		current().zoomAt(scale, float64(width)/2, float64(height)/2)
+		area.QueueDraw()
+	}
+
+	// pan moves the image by a tenth of the window in the given direction.
+	pan := func(dx, dy int) {
+		img := current()
+		width, height := area.GetAllocatedWidth(), area.GetAllocatedHeight()
+		img.orig.X += dx * width * 10 / img.scale
+		img.orig.Y += dy * height * 10 / img.scale
+		img.fit = false
+		area.QueueDraw()
+	}
+
+	// nextShape selects the next region shape drawn with the mouse.
+	nextShape := func() {
+		regionShape = nextRegionShape(regionShape)
+		measure = ""
+		draft = nil
+		if regionShape == "" {
+			status.SetText("drag to pan the image")
+		} else {
+			status.SetText(fmt.Sprintf("drag to draw a %s region", regionShape))
+		}
+		area.QueueDraw()
+	}
+
+	// finishPolygon adds the polygon being drawn to the regions.
+	finishPolygon := func() {
+		if len(draft) >= 3 {
+			regions = append(regions, newRegion("polygon", draft, current().wcs))
+		}
+		draft = nil
+		area.QueueDraw()
+	}
+
+	deleteRegion := func() {
+		if selected >= 0 && selected < len(regions) {
+			regions = append(regions[:selected], regions[selected+1:]...)
+		}
+		selected = -1
+		area.QueueDraw()
+		updateStats()
+	}
+
+	saveRegions := func() {
+		name := *regionFile
+		if name == "" {
+			name = "fitsview.reg"
+		}
+		if err := saveRegionFile(name, regions); err != nil {
+			log.Printf("could not save regions: %v\n", err)
+			return
+		}
+		status.SetText(fmt.Sprintf("saved %d regions to %s", len(regions), name))
+	}
+
+	// setMeasure selects the measurement tool, or the panning when tool
+	// is already selected.
+	setMeasure := func(tool string) {
+		draft = nil
+		regionShape = ""
+		switch {
+		case measure == tool:
+			measure = ""
+			status.SetText("drag to pan the image")
+		case tool == "line":
+			measure = tool
+			status.SetText("drag to plot a line profile")
+		default:
+			measure = tool
+			status.SetText("click a star to measure its profile")
+		}
+		area.QueueDraw()
+	}
+
+	// The profiles are plotted and written to the standard output as CSV.
+	showLine := func(p0, p1 fpoint) {
+		img := current()
+		dist, values := lineProfile(img.floatImage, p0, p1)
+		if err := writeLineCSV(os.Stdout, p0, p1, dist, values); err != nil {
+			log.Printf("could not write line profile: %v\n", err)
+		}
+		note := fmt.Sprintf("from x=%.1f y=%.1f to x=%.1f y=%.1f", p0.x+0.5, p0.y+0.5, p1.x+0.5, p1.y+0.5)
+		plot.plot("Line profile", "distance (pixels)", "value", dist, values, false, note)
+	}
+	showStar := func(p fpoint) {
+		prof, err := radialProfile(current().floatImage, p, *starRadius)
+		if err != nil {
+			status.SetText(fmt.Sprintf("could not measure the star: %v", err))
+			return
+		}
+		if err := writeProfileCSV(os.Stdout, prof); err != nil {
+			log.Printf("could not write star profile: %v\n", err)
+		}
+		note := fmt.Sprintf("centroid x=%.2f y=%.2f  FWHM=%.2f pixels  peak=%g  background=%g",
+			prof.centroid.x+0.5, prof.centroid.y+0.5, prof.fwhm, prof.peak, prof.background)
+		if w := current().wcs; w != nil {
+			note += fmt.Sprintf("  FWHM=%.2f\"", prof.fwhm*w.PixelScale()*3600)
+		}
+		status.SetText(note)
+		plot.plot("Star profile", "radius (pixels)", "value", prof.radius, prof.values, true, note)
+	}
+
+	saveExpr := func() {
+		img := current()
+		if img.expr == nil {
+			status.SetText("the current image is not the result of -expr")
+			return
+		}
+		if err := images.load(img); err != nil {
+			log.Printf("could not compute the expression: %v\n", err)
+			return
+		}
+		history := []string{"fitsview: " + img.expr.text}
+		for i, name := range img.expr.vars {
+			history = append(history, fmt.Sprintf("fitsview: %s=%s[%d]", name, img.expr.operands[i].file, img.expr.operands[i].hdu))
+		}
+		if err := saveFITS(*exprOut, img.floatImage, img.header, history...); err != nil {
+			log.Printf("could not save the result: %v\n", err)
+			return
+		}
+		status.SetText(fmt.Sprintf("saved %s to %s", img.expr.text, *exprOut))
+	}
+
+	markFile := func() {
+		marked = toggleMark(marked, cur.file)
+		drawImage(cur.file)
+	}
+
+	// Blink the marked files, with the same view.
+	var blinkSource glib.SourceHandle
+	blinking := false
+	blink := func() {
+		if blinking {
+			glib.SourceRemove(blinkSource)
+			blinking = false
+			status.SetText("blink stopped")
+			return
+		}
+		if len(marked) < 2 {
+			status.SetText("mark at least two files with m to blink them")
+			return
+		}
+		blinking = true
+		blinkSource = glib.TimeoutAdd(uint(blinkDelay.Milliseconds()), func() bool {
+			if len(marked) < 2 {
+				blinking = false
+				return false
+			}
+			prev := current()
+			cur.file = nextMarked(marked, cur.file)
+			cur.img, cur.plane = 0, 0
+			drawImage(cur.file)
+			alignView(current(), prev, area.GetAllocatedWidth(), area.GetAllocatedHeight(), *alignWCS)
+			return true
+		})
+	}
+
+	// toggleSplit shows a reference file on the right of the image: the
+	// first marked file, or the next file.
+	toggleSplit := func() {
+		if split >= 0 {
+			split = -1
+			rendered2 = nil
+			area2.Hide()
+			return
+		}
+		if nbFiles < 2 {
+			status.SetText("the split view needs two files")
+			return
+		}
+		split = (cur.file + 1) % nbFiles
+		for _, i := range marked {
+			if i != cur.file {
+				split = i
+				break
+			}
+		}
+		area2.SetNoShowAll(false)
+		area2.Show()
+		drawImage(cur.file)
+	}
+
+	toggleRGB := func() {
+		if *rgbSpec == "" {
+			status.SetText("no RGB composite, see the -rgb option")
+			return
+		}
+		showRGB = !showRGB
+		drawImage(cur.file)
+	}
+
+	toggleAlign := func() {
+		*alignWCS = !*alignWCS
+		if *alignWCS {
+			status.SetText("compared images are aligned on their WCS")
+		} else {
+			status.SetText("compared images are aligned on their pixels")
+		}
+		area2.QueueDraw()
+	}
+
+	// New files of the watched directory are appended to the files, and
+	// shown with the view of the current image unless paused.
+	paused := false
+	addFile := func(finfo fileInfo) {
+		if hasFile(infos, finfo.Name) || hasFile(tableFiles, finfo.Name) {
+			return
+		}
+		if len(finfo.Images) == 0 {
+			// Only shown by the table browser.
+			tableFiles = append(tableFiles, finfo)
+			tables.setFiles(allFiles())
+			return
+		}
+		view := current()
+		for i := range finfo.Images {
+			img := &finfo.Images[i]
+			img.scale, img.orig, img.fit = view.scale, view.orig, view.fit
+			img.qmin, img.qmax = view.qmin, view.qmax
+		}
+		infos = append(infos, finfo)
+		nbFiles = len(infos)
+		if len(finfo.Tables) > 0 {
+			tables.setFiles(allFiles())
+		}
+		if paused {
+			status.SetText(fmt.Sprintf("new file %s (%d files)", finfo.Name, nbFiles))
+			return
+		}
+		cur.file, cur.img, cur.plane = nbFiles-1, 0, 0
 		drawImage(cur.file)
+	}
+	if watched != nil {
+		go func() {
+			for fname := range watched {
+				finfo, err := scanFile(fname)
+				if err != nil {
+					log.Printf("could not read new file: %v\n", err)
+				}
+				if len(finfo.Images) == 0 && len(finfo.Tables) == 0 {
+					continue
+				}
+				glib.IdleAdd(func() bool {
+					addFile(finfo)
+					return false
+				})
+			}
+		}()
+	}
+
+	togglePause := func() {
+		paused = !paused
+		if paused {
+			status.SetText("new files are not shown")
+		} else {
+			status.SetText("new files are shown as they arrive")
+		}
+	}
+
+	// Pan by dragging the image with the primary button, or draw a region
+	// when a region shape is selected. A click selects a region.
+	var drag struct {
+		on    bool
+		moved bool
+		x, y  float64
+		orig  image.Point
+	}
+	area.Connect("button-press-event", func(da *gtk.DrawingArea, ev *gdk.Event) bool {
+		btn := gdk.EventButtonNewFromEvent(ev)
+		img := current()
+		if img.hduData == nil {
+			return false
+		}
+		ix, iy := img.toImage(btn.X(), btn.Y())
+		p := fpoint{ix, iy}
+		switch {
+		case btn.Button() == gdk.BUTTON_SECONDARY && regionShape == "polygon":
+			finishPolygon()
+		case btn.Button() != gdk.BUTTON_PRIMARY:
+		case measure == "star":
+			showStar(p)
+		case measure == "line":
+			draft = []fpoint{p, p}
+		case regionShape == "point":
+			regions = append(regions, newRegion("point", []fpoint{p}, img.wcs))
+			da.QueueDraw()
+		case regionShape == "polygon":
+			draft = append(draft, p)
+			da.QueueDraw()
+		case regionShape != "":
+			draft = []fpoint{p, p}
+		default:
+			drag.on, drag.moved = true, false
+			drag.x, drag.y = btn.X(), btn.Y()
+			drag.orig = img.orig
+		}
+		return true
+	})
+	area.Connect("button-release-event", func(da *gtk.DrawingArea, ev *gdk.Event) bool {
+		btn := gdk.EventButtonNewFromEvent(ev)
+		img := current()
+		switch {
+		case len(draft) == 2 && measure == "line":
+			if draft[0] != draft[1] {
+				showLine(draft[0], draft[1])
+			}
+			draft = nil
+			da.QueueDraw()
+		case len(draft) == 2 && regionShape != "polygon":
+			if draft[0] != draft[1] {
+				regions = append(regions, newRegion(regionShape, draft, img.wcs))
+			}
+			draft = nil
+			da.QueueDraw()
+		case drag.on && !drag.moved:
+			ix, iy := img.toImage(btn.X(), btn.Y())
+			selected = regionAt(img, fpoint{ix, iy})
+			source = -1
+			if selected < 0 && showSources && sources != nil {
+				// Sources are selected within 6 pixels of the screen.
+				source = sources.nearest(img.wcs, fpoint{ix, iy}, 6*100/float64(img.scale))
+				if source >= 0 {
+					sourceWin.show(sources, source)
+				}
+			}
+			da.QueueDraw()
+			updateStats()
+		}
+		drag.on = false
+		return true
+	})
+	area.Connect("motion-notify-event", func(da *gtk.DrawingArea, ev *gdk.Event) bool {
+		x, y := gdk.EventMotionNewFromEvent(ev).MotionVal()
+		img := current()
+		status.SetText(inspect(img, x, y))
+		if len(draft) == 2 && regionShape != "polygon" {
+			ix, iy := img.toImage(x, y)
+			draft[1] = fpoint{ix, iy}
+			da.QueueDraw()
+			return true
+		}
+		if !drag.on {
+			return false
+		}
+		s := float64(img.scale) / 100
+		img.orig = image.Point{
+			X: drag.orig.X - int(math.Round((x-drag.x)/s)),
+			Y: drag.orig.Y - int(math.Round((y-drag.y)/s)),
+		}
+		img.fit = false
+		drag.moved = true
+		da.QueueDraw()
+		return true
+	})
+
+	// Zoom with the mouse wheel, around the pointer.
+	area.Connect("scroll-event", func(da *gtk.DrawingArea, ev *gdk.Event) bool {
+		scroll := gdk.EventScrollNewFromEvent(ev)
+		img := current()
+		switch dir := scroll.Direction(); {
+		case dir == gdk.SCROLL_UP || (dir == gdk.SCROLL_SMOOTH && scroll.DeltaY() < 0):
+			img.zoomAt(zoomIn(img.scale), scroll.X(), scroll.Y())
+		case dir == gdk.SCROLL_DOWN || (dir == gdk.SCROLL_SMOOTH && scroll.DeltaY() > 0):
+			img.zoomAt(zoomOut(img.scale), scroll.X(), scroll.Y())
+		default:
+			return false
+		}
+		da.QueueDraw()
+		return true
 	})
-	customActionGroup.AddAction(aNextFile)
-	win.AddAction(aNextFile)
 
-	aPrevFile := glib.SimpleActionNew("prevfile", nil)
-	aPrevFile.Connect("activate", func() {
-		cur.Prev(nbFiles)
+	planeScale.Connect("value-changed", func(sc *gtk.Scale) {
+		if updatingPlane {
+			return
+		}
+		cur.plane = int(sc.GetValue()) - 1
 		drawImage(cur.file)
 	})
-	customActionGroup.AddAction(aPrevFile)
-	win.AddAction(aPrevFile)
 
-	keyMap := map[uint]func(){
-		gdk.KEY_q: func() {
-			application.Quit()
+	footer.onChange = func(qmin, qmax float64) {
+		if qmin >= qmax {
+			log.Printf("invalid quantiles: %v >= %v\n", qmin, qmax)
+			return
+		}
+		img := &infos[cur.file].Images[cur.img]
+		img.qmin, img.qmax = qmin, qmax
+		drawImage(cur.file)
+	}
+
+	// Dragging on the histogram sets the display limits of the image.
+	statsWin.onLimits = func(vmin, vmax float64) {
+		img := current()
+		pixels := img.sortedPixels()
+		qmin, qmax := quantileOf(pixels, vmin), quantileOf(pixels, vmax)
+		if qmin >= qmax {
+			// No pixel between the limits.
+			log.Printf("invalid quantiles: %v >= %v\n", qmin, qmax)
+			return
+		}
+		img.qmin, img.qmax = qmin, qmax
+		drawImage(cur.file)
+	}
+
+	toggleGrid := func() {
+		if grid.GetVisible() {
+			grid.Hide()
+			return
+		}
+		updateGrid()
+		grid.Present()
+	}
+	// Clicking a thumbnail shows its image.
+	grid.onSelect = func(file, img int) {
+		cur.file, cur.img, cur.plane = file, img, 0
+		drawImage(cur.file)
+	}
+
+	toggleTables := func() {
+		if tables.GetVisible() {
+			tables.Hide()
+			return
+		}
+		tables.setFile(infos[cur.file])
+		tables.Present()
+	}
+
+	toggleSources := func() {
+		switch {
+		case sources == nil:
+			status.SetText("no catalogue, see -catalog")
+			return
+		case current().wcs == nil:
+			status.SetText("the sources can not be drawn on an image without WCS")
+		}
+		showSources = !showSources
+		area.QueueDraw()
+	}
+
+	toggleStats := func() {
+		statsWin.toggle()
+		updateStats()
+	}
+
+	nextPlane := func() {
+		if cur.plane+1 < current().planes {
+			cur.plane++
+			drawImage(cur.file)
+		}
+	}
+	prevPlane := func() {
+		if cur.plane > 0 {
+			cur.plane--
+			drawImage(cur.file)
+		}
+	}
+	nextHDU := func(step int) {
+		if n := len(infos[cur.file].Images); n > 1 {
+			cur.img = (cur.img + step + n) % n
+			cur.plane = 0
+			drawImage(cur.file)
+		}
+	}
+
+	var palette *paletteView
+	handlers := map[string]func(){
+		"next-file": func() {
+			cur.Next(nbFiles)
+			drawImage(cur.file)
 		},
-		gdk.KEY_Left: func() {
+		"prev-file": func() {
 			cur.Prev(nbFiles)
 			drawImage(cur.file)
 		},
-		gdk.KEY_Right: func() {
-			cur.Next(nbFiles)
+		"next-hdu":   func() { nextHDU(1) },
+		"prev-hdu":   func() { nextHDU(-1) },
+		"next-plane": nextPlane,
+		"prev-plane": prevPlane,
+		"zoom-in":    func() { zoom(zoomIn(current().scale)) },
+		"zoom-out":   func() { zoom(zoomOut(current().scale)) },
+		"zoom-100":   func() { zoom(100) },
+		"fit": func() {
+			img := current()
+			img.fit = !img.fit
+			area.QueueDraw()
+		},
+		"pan-left":  func() { pan(-1, 0) },
+		"pan-right": func() { pan(1, 0) },
+		"pan-up":    func() { pan(0, -1) },
+		"pan-down":  func() { pan(0, 1) },
+		"cycle-stretch": func() {
+			*stretchName = nextStretch(*stretchName)
 			drawImage(cur.file)
 		},
-		gdk.KEY_Up: func() {
-			if len(infos[cur.file].Images) > 1 {
-				cur.img = (cur.img + 1) % len(infos[cur.file].Images)
-				drawImage(cur.file)
-			}
+		"cycle-colormap": func() {
+			*cmapName = nextColormap(*cmapName)
+			drawImage(cur.file)
 		},
-		gdk.KEY_Down: func() {
-			if len(infos[cur.file].Images) > 1 {
-				cur.img = (cur.img - 1)
-				if cur.img < 0 {
-					cur.img = len(infos[cur.file].Images) + cur.img
-				}
-				drawImage(cur.file)
+		"resample": func() {
+			if *resampling == "nearest" {
+				*resampling = "bilinear"
+			} else {
+				*resampling = "nearest"
 			}
+			area.QueueDraw()
+		},
+		"header":         panel.toggle,
+		"stats":          toggleStats,
+		"tables":         toggleTables,
+		"grid":           toggleGrid,
+		"sources":        toggleSources,
+		"mark":           markFile,
+		"blink":          blink,
+		"split":          toggleSplit,
+		"align":          toggleAlign,
+		"rgb":            toggleRGB,
+		"pause":          togglePause,
+		"region-shape":   nextShape,
+		"finish-polygon": finishPolygon,
+		"delete-region":  deleteRegion,
+		"cancel": func() {
+			draft = nil
+			selected, source = -1, -1
+			updateStats()
+			area.QueueDraw()
+		},
+		"save-regions": saveRegions,
+		"line-profile": func() { setMeasure("line") },
+		"star-profile": func() { setMeasure("star") },
+		"save-expr":    saveExpr,
+		"palette":      func() { palette.popup() },
+		"quit": func() {
+			application.ActivateAction("quit", nil)
 		},
 	}
 
+	// Create the actions in the custom action group, with their menu
+	// entries.
+	for _, a := range viewerActions {
+		run, found := handlers[a.name]
+		if !found {
+			log.Fatalf("No handler for action %s", a.name)
+		}
+		action := glib.SimpleActionNew(a.name, nil)
+		action.Connect("activate", run)
+		customActionGroup.AddAction(action)
+		win.AddAction(action)
+
+		label := a.label
+		if keys := bindings.shortcut(a.name); keys != "" {
+			label += " [" + keys + "]"
+		}
+		menu.Append(label, "custom."+a.name)
+	}
+	palette = paletteWindow(win, bindings, func(name string) {
+		handlers[name]()
+		win.QueueDraw()
+	})
+
+	actionKeys := bindings.actionKeys()
+	for _, a := range viewerActions {
+		for _, key := range bindings[a.name] {
+			if gdk.KeyvalFromName(keyName(key)) == gdk.KEY_VoidSymbol {
+				log.Printf("unknown key %q of action %s\n", key, a.name)
+			}
+		}
+	}
 	win.Connect("key-press-event", func(win *gtk.ApplicationWindow, ev *gdk.Event) {
 		keyEvent := &gdk.EventKey{ev}
-		if move, found := keyMap[keyEvent.KeyVal()]; found {
-			move()
+		if panel.search.HasFocus() {
+			// Typing in the search box.
+			if keyEvent.KeyVal() == gdk.KEY_Escape {
+				area.GrabFocus()
+			}
+			return
+		}
+		state := gdk.ModifierType(keyEvent.State())
+		key := keyString(gdk.KeyvalName(keyEvent.KeyVal()), state&gdk.CONTROL_MASK != 0, state&gdk.MOD1_MASK != 0)
+		if name, found := actionKeys[key]; found {
+			handlers[name]()
 			win.QueueDraw()
 		}
 	})
 
-	// Set the default window size.
-	img := &infos[cur.file].Images[cur.img]
-	win.SetDefaultSize(img.Bounds().Dx(), img.Bounds().Dy())
+	// Set the default window size, large frames are fitted to the window.
+	const maxWidth, maxHeight = 1200, 900
+	width, height := current().Bounds().Dx(), current().Bounds().Dy()
+	if width > maxWidth {
+		width = maxWidth
+	}
+	if height > maxHeight {
+		height = maxHeight
+	}
+	win.SetDefaultSize(width, height)
+
+	return win, allFiles
+}
+
+// newTablesWindow creates the table browser of the files infos as the
+// main window, and returns it with a function returning the files.
+func newTablesWindow(application *gtk.Application, infos []fileInfo) (*gtk.ApplicationWindow, func() []fileInfo) {
+	win, err := gtk.ApplicationWindowNew(application)
+	if err != nil {
+		log.Fatal("Unable to create window:", err)
+	}
+	tables := newTableView(&win.Window, plotWindow(win))
+	tables.setFiles(infos)
+	tables.setFile(infos[0])
+	return win, func() []fileInfo { return infos }
+}
 
-	return win
+// footer holds the widgets of the footer bar.
+type footer struct {
+	*gtk.Box
+	minBtn, maxBtn *gtk.SpinButton
+	vminLab        *gtk.Label
+	vmaxLab        *gtk.Label
+	cbar           *gtk.Image
+
+	// onChange is called with the new quantiles when the user changes
+	// one of the spin buttons.
+	onChange func(qmin, qmax float64)
+	updating bool
 }
 
-func footerBar() *gtk.Box {
+func footerBar() *footer {
 	hbox, _ := gtk.BoxNew(gtk.ORIENTATION_HORIZONTAL, 0)
 
 	minLab, err := gtk.LabelNew("qmin")
@@ -259,60 +1368,120 @@ func footerBar() *gtk.Box {
 	maxBtn.SetValue(99)
 	hbox.PackStart(maxBtn, false, false, 10)
 
-	minBtn.Connect("value-changed", func(sb *gtk.SpinButton) {
-		fmt.Printf("val: %v\n", float64(sb.GetValue()))
-	})
-
-	maxBtn.Connect("value-changed", func(sb *gtk.SpinButton) {
-		fmt.Printf("val: %v\n", float64(sb.GetValue()))
-	})
+	vmaxLab, err := gtk.LabelNew("")
+	if err != nil {
+		log.Fatal("Unable to create label:", err)
+	}
+	hbox.PackEnd(vmaxLab, false, false, 10)
+	cbar, err := gtk.ImageNew()
+	if err != nil {
+		log.Fatal("Unable to create image:", err)
+	}
+	hbox.PackEnd(cbar, false, false, 0)
+	vminLab, err := gtk.LabelNew("")
+	if err != nil {
+		log.Fatal("Unable to create label:", err)
+	}
+	hbox.PackEnd(vminLab, false, false, 10)
+
+	f := &footer{
+		Box:     hbox,
+		minBtn:  minBtn,
+		maxBtn:  maxBtn,
+		vminLab: vminLab,
+		vmaxLab: vmaxLab,
+		cbar:    cbar,
+	}
+	changed := func(sb *gtk.SpinButton) {
+		if f.updating || f.onChange == nil {
+			return
+		}
+		f.onChange(minBtn.GetValue()/100, maxBtn.GetValue()/100)
+	}
+	minBtn.Connect("value-changed", changed)
+	maxBtn.Connect("value-changed", changed)
 
-	return hbox
+	return f
 }
 
-func processFiles() []fileInfo {
-	infos := make([]fileInfo, 0, len(flag.Args()))
-	// Parsing input files.
-	for _, fname := range flag.Args() {
+// setQuantiles shows the quantiles of the current image in the spin
+// buttons, without calling onChange.
+func (f *footer) setQuantiles(qmin, qmax float64) {
+	f.updating = true
+	defer func() { f.updating = false }()
+	f.minBtn.SetValue(qmin * 100)
+	f.maxBtn.SetValue(qmax * 100)
+}
 
-		finfo := fileInfo{Name: fname}
+// setColorbar shows the colormap and the display limits of the current
+// image.
+func (f *footer) setColorbar(cmap *Colormap, stretch Stretch, vmin, vmax float64) {
+	const width, height = 256, 16
+	pixbuf, err := pixBufFromRGBA(colorbar(cmap, stretch, width, height))
+	if err != nil {
+		log.Printf("could not create colorbar: %v\n", err)
+		return
+	}
+	f.cbar.SetFromPixbuf(pixbuf)
+	f.vminLab.SetText(fmt.Sprintf("%g", vmin))
+	f.vmaxLab.SetText(fmt.Sprintf("%g", vmax))
+}
 
-		r, err := openStream(fname)
+// inputFiles returns the files given on the command line followed by
+// the files of the watched directory, the channels of the RGB composite,
+// or the result of the expression followed by its operands.
+func inputFiles() []fileInfo {
+	if *exprText != "" {
+		infos, err := processExpr(*exprText, flag.Args())
 		if err != nil {
-			log.Fatalf("Can not open the input file: %v", err)
+			log.Fatal("Could not read the images of the expression:", err)
 		}
-		defer r.Close()
-
-		// Opening the FITS file.
-		f, err := fitsio.Open(r)
-		if err != nil {
-			log.Fatalf("Can not open the FITS input file: %v", err)
-		}
-		defer f.Close()
-
-		// Getting the file HDUs.
-		hdus := f.HDUs()
-		for _, hdu := range hdus {
-			// Getting the header informations.
-			header := hdu.Header()
-			axes := header.Axes()
-
-			// Discarding HDU with no axes.
-			if len(axes) != 0 {
-				if hdu, ok := hdu.(fitsio.Image); ok {
-					img := hdu.Image()
-					if img != nil {
-						finfo.Images = append(finfo.Images, imageInfo{
-							Image: img,
-							scale: 100,
-							orig:  image.Point{},
-						})
-					}
+		return infos
+	}
+	if *rgbSpec == "" {
+		var infos []fileInfo
+		if flag.NArg() == 0 && restored != nil {
+			// Files of the session may have been moved or removed.
+			infos = scanFiles(restored.Files)
+		} else {
+			infos = processFiles(flag.Args())
+		}
+		if *watchPath != "" {
+			names, err := listFITS(*watchPath)
+			if err != nil {
+				log.Fatal("Could not list the watched directory:", err)
+			}
+			// Files of the directory may be incomplete.
+			for _, finfo := range scanFiles(names) {
+				if !hasFile(infos, finfo.Name) {
+					infos = append(infos, finfo)
 				}
 			}
 		}
+		// Frames of the stream received so far.
+		infos = append(infos, scanFiles(frames.list())...)
+		return infos
+	}
+	infos, err := processChannels(strings.Split(*rgbSpec, ","))
+	if err != nil {
+		log.Fatal("Could not read the RGB channels:", err)
+	}
+	return infos
+}
 
-		if len(finfo.Images) > 0 {
+// processFiles scans the headers of the input files, the images are
+// decoded when displayed.
+func processFiles(fnames []string) []fileInfo {
+	infos := make([]fileInfo, 0, len(fnames))
+	for _, fname := range fnames {
+		finfo, err := scanFile(fname)
+		if err != nil {
+			if len(finfo.Images) == 0 && len(finfo.Tables) == 0 {
+				log.Fatalf("Can not open the FITS input file: %v", err)
+			}
+			log.Printf("%v\n", err)
+		}
+		if len(finfo.Images) > 0 || len(finfo.Tables) > 0 {
 			infos = append(infos, finfo)
 		}
 	}
@@ -320,12 +1489,110 @@ func processFiles() []fileInfo {
 	return infos
 }
 
-func computeQuantiles(img image.Image, qmin, qmax float64) (float64, float64) {
-	pixels, _ := getPixels(img)
+// scanFile scans the headers of the FITS file fname. The images before
+// an error are returned with the error.
+func scanFile(fname string) (fileInfo, error) {
+	finfo := fileInfo{Name: fname}
+	r, err := openStream(fname)
+	if err != nil {
+		return finfo, err
+	}
+	descs, tables, err := scanFITS(r, fname)
+	r.Close()
+	finfo.Tables = tables
+	for _, desc := range descs {
+		finfo.Images = append(finfo.Images, imageInfo{
+			hduDesc: desc,
+			scale:   100,
+			orig:    image.Point{},
+			fit:     true,
+			qmin:    0.01,
+			qmax:    0.99,
+		})
+	}
+	return finfo, err
+}
+
+// scanFiles scans the headers of the files fnames, skipping the files
+// which can not be read.
+func scanFiles(fnames []string) []fileInfo {
+	var infos []fileInfo
+	for _, fname := range fnames {
+		finfo, err := scanFile(fname)
+		if err != nil {
+			log.Printf("%v\n", err)
+		}
+		if len(finfo.Images) > 0 || len(finfo.Tables) > 0 {
+			infos = append(infos, finfo)
+		}
+	}
+	return infos
+}
+
+// splitTables returns the files of infos with images, and the files with
+// only tables.
+func splitTables(infos []fileInfo) (images, tables []fileInfo) {
+	for _, finfo := range infos {
+		if len(finfo.Images) > 0 {
+			images = append(images, finfo)
+		} else {
+			tables = append(tables, finfo)
+		}
+	}
+	return images, tables
+}
+
+// hasFile reports whether the file fname is among infos.
+func hasFile(infos []fileInfo, fname string) bool {
+	for _, finfo := range infos {
+		if finfo.Name == fname {
+			return true
+		}
+	}
+	return false
+}
+
+// waitFirstFile waits for an image among the new files of from, the
+// watched directory or the stream, when no input file has any.
+func waitFirstFile(files <-chan string, from string) {
+	if infos, _ := splitTables(inputFiles()); len(infos) > 0 {
+		return
+	}
+	log.Printf("waiting for FITS files in %s\n", from)
+	for fname := range files {
+		if finfo, _ := scanFile(fname); len(finfo.Images) > 0 {
+			return
+		}
+	}
+}
 
-	// Sort the values.
-	inds := make([]int, len(pixels))
-	floats.Argsort(pixels, inds)
+// prefetch decodes in the background the images shown first in the files
+// next to the file i.
+func prefetch(infos []fileInfo, i int) {
+	n := len(infos)
+	if n == 1 {
+		return
+	}
+	next, prev := infos[(i+1)%n].Images[0], infos[(i+n-1)%n].Images[0]
+	if n == 2 {
+		images.prefetch(next.hduDesc)
+		return
+	}
+	images.prefetch(next.hduDesc, prev.hduDesc)
+}
+
+// errBlankImage is returned by computeQuantiles for an image without any
+// valid pixel.
+var errBlankImage = errors.New("all the pixels are blank")
+
+// computeQuantiles returns the values of the quantiles qmin and qmax of
+// the valid pixels of img. It returns NaN limits and errBlankImage when
+// all the pixels are NaN or BLANK.
+func computeQuantiles(img *imageInfo, qmin, qmax float64) (float64, float64, error) {
+	pixels := img.sortedPixels()
+	if len(pixels) == 0 {
+		return math.NaN(), math.NaN(), errBlankImage
+	}
 	log.Printf("min: %v, max: %v\n", pixels[0], pixels[len(pixels)-1])
 
 	// mean, std := stat.MeanStdDev(pixels, nil)
@@ -335,34 +1602,29 @@ func computeQuantiles(img image.Image, qmin, qmax float64) (float64, float64) {
 	q2 := stat.Quantile(qmax, stat.Empirical, pixels, nil)
 	log.Printf("quantiles %.2f=%v, %.2f=%v\n", qmin, q1, qmax, q2)
 
-	return q1, q2
+	return q1, q2, nil
 }
 
+// openStream opens a local or remote FITS file, which can be gzip or
+// bzip2 compressed.
 func openStream(name string) (io.ReadCloser, error) {
-	switch {
-	case strings.HasPrefix(name, "http://") || strings.HasPrefix(name, "https://"):
-		resp, err := http.Get(name)
-		if err != nil {
-			return nil, err
-		}
-		defer resp.Body.Close()
-
-		f, err := ioutil.TempFile("", "view-fits-")
-		if err != nil {
-			return nil, err
-		}
-
-		_, err = io.Copy(f, resp.Body)
-		if err != nil {
-			f.Close()
-			return nil, err
-		}
+	r, err := openRaw(name)
+	if err != nil {
+		return nil, err
+	}
+	return decompress(r)
+}
 
-		// make sure we have at least a full FITS block
-		f.Seek(0, 2880)
-		f.Seek(0, 0)
+func openRaw(name string) (io.ReadCloser, error) {
+	if r, ok, err := frames.open(name); ok {
+		return r, err
+	}
+	switch {
+	case name == "-":
+		return openStdin()
 
-		return f, nil
+	case strings.HasPrefix(name, "http://") || strings.HasPrefix(name, "https://"):
+		return remote.Open(name)
 
 	case strings.HasPrefix(name, "file://"):
 		name = name[len("file://"):]
@@ -372,66 +1634,89 @@ func openStream(name string) (io.ReadCloser, error) {
 	}
 }
 
-func pixBufFromImage(picture image.Image, vmin, vmax float64) (*gdk.Pixbuf, error) {
-	width := picture.Bounds().Max.X
-	height := picture.Bounds().Max.Y
+func pixBufFromRGBA(rgba *image.RGBA) (*gdk.Pixbuf, error) {
+	width := rgba.Bounds().Dx()
+	height := rgba.Bounds().Dy()
 
 	pixbuf, err := gdk.PixbufNew(gdk.COLORSPACE_RGB, true, 8, width, height)
 	if nil != err {
 		return nil, err
 	}
 	pixelSlice := pixbuf.GetPixels()
+	stride := pixbuf.GetRowstride()
 
 	const bytesPerPixel = 4
-	i := 0
 	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			colour := picture.At(x, y)
-			r, _, _, _ := colour.RGBA()
-
-			// scale
-			var val uint32
-			if r < uint32(vmin) {
-				val = 0
-			} else if r > uint32(vmax) {
-				val = maxUint32
-			} else {
-				val = uint32((float64(r) - vmin) / (vmax - vmin) * maxUint32)
-			}
-			bval := uint32ToByte(val)
-			pixelSlice[i] = bval   // r
-			pixelSlice[i+1] = bval // g
-			pixelSlice[i+2] = bval // b
-			pixelSlice[i+3] = 255
-
-			i += bytesPerPixel
-		}
+		copy(pixelSlice[y*stride:y*stride+width*bytesPerPixel], rgba.Pix[y*rgba.Stride:])
 	}
 
 	return pixbuf, nil
 }
 
-func uint32ToByte(value uint32) byte {
-	const ratio = float64(256) / float64(65536)
-	byteValue := ratio * float64(value)
-	if byteValue > 255 {
-		return byte(255)
+// inspect describes the pixel under the window coordinates (x, y): its
+// FITS coordinates, its physical value and its sky position.
+func inspect(img *imageInfo, x, y float64) string {
+	if img.hduData == nil {
+		return ""
+	}
+	ix, iy := img.toImage(x, y)
+	px, py := int(math.Floor(ix)), int(math.Floor(iy))
+	v, ok := img.value(px, py)
+	if !ok {
+		return ""
+	}
+	// FITS pixel coordinates start at 1.
+	text := fmt.Sprintf("x=%d y=%d value=%g", px+1, py+1, v)
+	if img.wcs != nil {
+		ra, dec := img.wcs.PixelToWorld(ix+0.5, iy+0.5)
+		text += fmt.Sprintf("  RA=%s Dec=%s", wcs.FormatRA(ra), wcs.FormatDec(dec))
+	}
+	return text
+}
+
+// regionAt returns the index of the last region containing the image
+// position p, -1 if none.
+func regionAt(img *imageInfo, p fpoint) int {
+	// Points are selected within 5 pixels of the screen.
+	tol := 5 * 100 / float64(img.scale)
+	for i := len(regions) - 1; i >= 0; i-- {
+		if regions[i].contains(img.wcs, p, tol) {
+			return i
+		}
 	}
-	return byte(byteValue)
+	return -1
 }
 
-// Get the bi-dimensional pixel array
-func getPixels(img image.Image) ([]float64, error) {
-	bounds := img.Bounds()
-	width, height := bounds.Max.X, bounds.Max.Y
+// saveRegionFile writes the regions to the DS9 region file name.
+func saveRegionFile(name string, regions []region) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
 
-	var pixels []float64
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			r, _, _, _ := img.At(x, y).RGBA()
-			if r != 0 {
-				pixels = append(pixels, float64(r))
-			}
+	if err := writeRegions(f, regions); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// contains reports whether name is in names.
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Get the defined (non-NaN) pixel values.
+func getPixels(img *floatImage) ([]float64, error) {
+	pixels := make([]float64, 0, len(img.Data))
+	for _, v := range img.Data {
+		if !math.IsNaN(v) {
+			pixels = append(pixels, v)
 		}
 	}
 
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestSortedPixels(t *testing.T) {
+	img := &imageInfo{
+		hduDesc: hduDesc{axes: []int{4, 2}, planes: 1},
+		hduData: &hduData{floatImage: &floatImage{
+			Data:   []float64{500, 100, math.NaN(), 300, 600, 200, 400, math.NaN()},
+			Width:  4,
+			Height: 2,
+		}},
+		qmin: 0.01,
+		qmax: 0.99,
+	}
+
+	pixels := img.sortedPixels()
+	if len(pixels) != 6 || !sort.Float64sAreSorted(pixels) {
+		t.Fatalf("invalid sorted pixels: %v", pixels)
+	}
+	if &img.sortedPixels()[0] != &pixels[0] {
+		t.Fatalf("sorted pixels were not cached")
+	}
+
+	vmin, vmax, err := computeQuantiles(img, 0.2, 0.8)
+	if err != nil || vmin != 200 || vmax != 500 {
+		t.Fatalf("invalid quantiles: got=(%v, %v, %v), want=(200, 500)", vmin, vmax, err)
+	}
+}
+
+func TestBlankQuantiles(t *testing.T) {
+	nan := math.NaN()
+	img := &imageInfo{hduData: &hduData{
+		floatImage: &floatImage{Data: []float64{nan, nan, nan, nan}, Width: 2, Height: 2},
+	}}
+	vmin, vmax, err := computeQuantiles(img, 0.01, 0.99)
+	if err != errBlankImage || !math.IsNaN(vmin) || !math.IsNaN(vmax) {
+		t.Fatalf("got=(%v, %v, %v), want NaN limits and errBlankImage", vmin, vmax, err)
+	}
+}
+
+func TestScanTableFiles(t *testing.T) {
+	dir := t.TempDir()
+	image := filepath.Join(dir, "image.fits")
+	table := filepath.Join(dir, "table.fits")
+	empty := filepath.Join(dir, "empty.fits")
+	files := map[string][]byte{
+		image: append(fitsHeader("SIMPLE  = T", "BITPIX  = 8", "NAXIS   = 2", "NAXIS1  = 10", "NAXIS2  = 10"),
+			make([]byte, blockSize)...),
+		table: bytes.Join([][]byte{
+			fitsHeader("SIMPLE  = T", "BITPIX  = 8", "NAXIS   = 0", "EXTEND  = T"),
+			fitsHeader("XTENSION= 'BINTABLE'", "BITPIX  = 8", "NAXIS   = 2", "NAXIS1  = 4",
+				"NAXIS2  = 2", "PCOUNT  = 0", "GCOUNT  = 1", "TFIELDS = 1", "TFORM1  = '1J'"),
+			make([]byte, blockSize),
+		}, nil),
+		empty: fitsHeader("SIMPLE  = T", "BITPIX  = 8", "NAXIS   = 0"),
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(name, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	images, tables := splitTables(scanFiles([]string{image, table, empty}))
+	if len(images) != 1 || images[0].Name != image {
+		t.Fatalf("invalid image files: got=%+v", images)
+	}
+	if len(tables) != 1 || tables[0].Name != table || len(tables[0].Tables) != 1 {
+		t.Fatalf("invalid table files: got=%+v", tables)
+	}
+}
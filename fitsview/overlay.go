@@ -0,0 +1,101 @@
+package main
+
+import (
+	"math"
+
+	"github.com/gotk3/gotk3/cairo"
+)
+
+// overlay draws shapes given in image coordinates over the image shown
+// in the window.
+type overlay struct {
+	cr   *cairo.Context
+	img  *imageInfo
+	zoom float64
+}
+
+func newOverlay(cr *cairo.Context, img *imageInfo) *overlay {
+	return &overlay{cr: cr, img: img, zoom: float64(img.scale) / 100}
+}
+
+// toWindow converts image coordinates to window coordinates.
+func (o *overlay) toWindow(p fpoint) (float64, float64) {
+	return (p.x - float64(o.img.orig.X)) * o.zoom, (p.y - float64(o.img.orig.Y)) * o.zoom
+}
+
+// polygon strokes the path through pts, closed if closed is true.
+func (o *overlay) polygon(pts []fpoint, closed bool) {
+	for i, p := range pts {
+		x, y := o.toWindow(p)
+		if i == 0 {
+			o.cr.MoveTo(x, y)
+		} else {
+			o.cr.LineTo(x, y)
+		}
+	}
+	if closed {
+		o.cr.ClosePath()
+	}
+	o.cr.Stroke()
+}
+
+// cross strokes a cross centred on p, of size pixels on the screen.
+func (o *overlay) cross(p fpoint, size float64) {
+	x, y := o.toWindow(p)
+	o.cr.MoveTo(x-size, y)
+	o.cr.LineTo(x+size, y)
+	o.cr.MoveTo(x, y-size)
+	o.cr.LineTo(x, y+size)
+	o.cr.Stroke()
+}
+
+// marker strokes a circle centred on p, of radius pixels on the screen.
+func (o *overlay) marker(p fpoint, radius float64) {
+	x, y := o.toWindow(p)
+	o.cr.NewPath()
+	o.cr.Arc(x, y, radius, 0, 2*math.Pi)
+	o.cr.Stroke()
+}
+
+// label writes text at the upper right of p.
+func (o *overlay) label(p fpoint, text string) {
+	x, y := o.toWindow(p)
+	o.cr.MoveTo(x+6, y-6)
+	o.cr.ShowText(text)
+}
+
+// region draws the outline of reg, and its text if any.
+func (o *overlay) region(reg region) {
+	pts, ok := reg.outline(o.img.wcs)
+	if !ok {
+		return
+	}
+	if reg.shape == "point" {
+		o.cross(pts[0], 5)
+	} else {
+		o.polygon(pts, true)
+	}
+	if reg.text != "" {
+		o.label(pts[0], reg.text)
+	}
+}
+
+// sources draws a marker on the sources of c, with their label if any.
+// The source selected is highlighted.
+func (o *overlay) sources(c *catalog, selected int) {
+	for i := range c.ra {
+		p, ok := c.position(o.img.wcs, i)
+		if !ok {
+			continue
+		}
+		if i == selected {
+			o.cr.SetSourceRGB(1, 0.3, 0.3)
+		} else {
+			o.cr.SetSourceRGB(0.3, 0.8, 1)
+		}
+		o.marker(p, 6)
+		if l := c.label(i); l != "" {
+			o.label(p, l)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"log"
+
+	"github.com/gotk3/gotk3/gdk"
+	"github.com/gotk3/gotk3/glib"
+	"github.com/gotk3/gotk3/gtk"
+)
+
+// Columns of the command palette store.
+const (
+	colActionLabel = iota
+	colActionKeys
+	colActionName
+)
+
+// paletteView is the command palette, listing the actions matching the
+// text typed in its search entry. Enter runs the highlighted action.
+type paletteView struct {
+	*gtk.Window
+	search *gtk.SearchEntry
+	tree   *gtk.TreeView
+	store  *gtk.ListStore
+
+	bindings keyBindings
+	matches  []viewerAction
+	index    int // highlighted action, in matches
+
+	// run is called with the name of the action chosen by the user.
+	run func(name string)
+}
+
+func paletteWindow(parent *gtk.ApplicationWindow, bindings keyBindings, run func(name string)) *paletteView {
+	win, err := gtk.WindowNew(gtk.WINDOW_TOPLEVEL)
+	if err != nil {
+		log.Fatal("Unable to create window:", err)
+	}
+	win.SetTitle("Commands")
+	win.SetTransientFor(parent)
+	win.SetModal(true)
+	win.SetDefaultSize(500, 400)
+	win.HideOnDelete()
+
+	vbox, _ := gtk.BoxNew(gtk.ORIENTATION_VERTICAL, 0)
+	win.Add(vbox)
+
+	search, err := gtk.SearchEntryNew()
+	if err != nil {
+		log.Fatal("Unable to create search entry:", err)
+	}
+	search.SetPlaceholderText("Search actions")
+	vbox.PackStart(search, false, false, 5)
+
+	store, err := gtk.ListStoreNew(glib.TYPE_STRING, glib.TYPE_STRING, glib.TYPE_STRING)
+	if err != nil {
+		log.Fatal("Unable to create list store:", err)
+	}
+	tree, err := gtk.TreeViewNewWithModel(store)
+	if err != nil {
+		log.Fatal("Unable to create tree view:", err)
+	}
+	tree.SetEnableSearch(false)
+	for _, c := range []struct {
+		title string
+		col   int
+	}{{"Action", colActionLabel}, {"Keys", colActionKeys}, {"Name", colActionName}} {
+		renderer, err := gtk.CellRendererTextNew()
+		if err != nil {
+			log.Fatal("Unable to create cell renderer:", err)
+		}
+		column, err := gtk.TreeViewColumnNewWithAttribute(c.title, renderer, "text", c.col)
+		if err != nil {
+			log.Fatal("Unable to create tree view column:", err)
+		}
+		column.SetResizable(true)
+		tree.AppendColumn(column)
+	}
+
+	scroll, err := gtk.ScrolledWindowNew(nil, nil)
+	if err != nil {
+		log.Fatal("Unable to create scrolled window:", err)
+	}
+	scroll.SetPolicy(gtk.POLICY_AUTOMATIC, gtk.POLICY_AUTOMATIC)
+	scroll.Add(tree)
+	vbox.PackStart(scroll, true, true, 0)
+
+	v := &paletteView{Window: win, search: search, tree: tree, store: store, bindings: bindings, run: run}
+	search.Connect("search-changed", v.refresh)
+	search.Connect("activate", func() { v.activate(v.index) })
+	tree.Connect("row-activated", func(tree *gtk.TreeView, path *gtk.TreePath) {
+		if indices := path.GetIndices(); len(indices) > 0 {
+			v.activate(indices[0])
+		}
+	})
+	// The arrows move the highlight while typing in the search entry.
+	win.Connect("key-press-event", func(win *gtk.Window, ev *gdk.Event) bool {
+		switch (&gdk.EventKey{ev}).KeyVal() {
+		case gdk.KEY_Escape:
+			v.Hide()
+		case gdk.KEY_Up:
+			v.highlight(v.index - 1)
+		case gdk.KEY_Down:
+			v.highlight(v.index + 1)
+		default:
+			return false
+		}
+		return true
+	})
+	vbox.ShowAll()
+	return v
+}
+
+// popup shows the palette with all the actions.
+func (v *paletteView) popup() {
+	v.search.SetText("")
+	v.refresh()
+	v.Present()
+	v.search.GrabFocus()
+}
+
+// refresh lists the actions matching the search entry.
+func (v *paletteView) refresh() {
+	query, _ := v.search.GetText()
+	v.matches = matchActions(v.bindings, query)
+	v.store.Clear()
+	for _, a := range v.matches {
+		err := v.store.Set(v.store.Append(),
+			[]int{colActionLabel, colActionKeys, colActionName},
+			[]interface{}{a.label, v.bindings.shortcut(a.name), a.name})
+		if err != nil {
+			log.Printf("could not add action %s: %v\n", a.name, err)
+		}
+	}
+	v.index = 0
+	v.highlight(0)
+}
+
+// highlight moves the highlight to the action i of the matches.
+func (v *paletteView) highlight(i int) {
+	if i < 0 || i >= len(v.matches) {
+		return
+	}
+	v.index = i
+	path, err := gtk.TreePathNewFromIndicesv([]int{i})
+	if err != nil {
+		return
+	}
+	v.tree.SetCursor(path, nil, false)
+}
+
+// activate hides the palette and runs the action i of the matches.
+func (v *paletteView) activate(i int) {
+	if i < 0 || i >= len(v.matches) {
+		return
+	}
+	v.Hide()
+	v.run(v.matches[i].name)
+}
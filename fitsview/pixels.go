@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/saimn/fitsio"
+)
+
+// floatImage is a 2-D array of physical pixel values, that is the raw
+// values with BSCALE and BZERO applied. Blank pixels are NaN.
+type floatImage struct {
+	Data   []float64
+	Width  int
+	Height int
+}
+
+// Bounds returns the domain of the image.
+func (f *floatImage) Bounds() image.Rectangle {
+	return image.Rect(0, 0, f.Width, f.Height)
+}
+
+// value returns the physical value of the pixel (x, y). ok is false
+// outside of the image.
+func (f *floatImage) value(x, y int) (v float64, ok bool) {
+	if x < 0 || y < 0 || x >= f.Width || y >= f.Height {
+		return 0, false
+	}
+	return f.Data[y*f.Width+x], true
+}
+
+// dataFormat describes how the raw values of a FITS data array are
+// converted to physical values.
+type dataFormat struct {
+	bitpix int
+	bscale float64
+	bzero  float64
+	blank  *int64 // raw value of undefined integer pixels, if any
+}
+
+// newDataFormat reads BITPIX, BSCALE, BZERO and BLANK from the header.
+func newDataFormat(hdr *fitsio.Header) dataFormat {
+	df := dataFormat{
+		bitpix: hdr.Bitpix(),
+		bscale: headerFloat(hdr, "BSCALE", 1),
+		bzero:  headerFloat(hdr, "BZERO", 0),
+	}
+	if card := hdr.Get("BLANK"); card != nil && df.bitpix > 0 {
+		switch v := card.Value.(type) {
+		case int:
+			blank := int64(v)
+			df.blank = &blank
+		case int64:
+			df.blank = &v
+		}
+	}
+	return df
+}
+
+// decodeImage decodes the first width x height values of the big-endian
+// data array raw into physical values.
+func decodeImage(raw []byte, width, height int, df dataFormat) (*floatImage, error) {
+	size := df.bitpix / 8
+	if size < 0 {
+		size = -size
+	}
+	switch df.bitpix {
+	case 8, 16, 32, 64, -32, -64:
+	default:
+		return nil, fmt.Errorf("invalid BITPIX %d", df.bitpix)
+	}
+	n := width * height
+	if len(raw) < n*size {
+		return nil, fmt.Errorf("truncated data array: %d bytes, want %d", len(raw), n*size)
+	}
+
+	data := make([]float64, n)
+	for i := range data {
+		if df.blank != nil && rawInt(raw, df.bitpix, i) == *df.blank {
+			data[i] = math.NaN()
+			continue
+		}
+		// Floating point NaNs are kept as is.
+		data[i] = df.bzero + df.bscale*pixelValue(raw, df.bitpix, i)
+	}
+	return &floatImage{Data: data, Width: width, Height: height}, nil
+}
+
+// pixelValue decodes the i-th value of a big-endian FITS data array of
+// type bitpix.
+func pixelValue(raw []byte, bitpix, i int) float64 {
+	switch bitpix {
+	case 8:
+		return float64(raw[i])
+	case 16:
+		return float64(int16(binary.BigEndian.Uint16(raw[2*i:])))
+	case 32:
+		return float64(int32(binary.BigEndian.Uint32(raw[4*i:])))
+	case 64:
+		return float64(int64(binary.BigEndian.Uint64(raw[8*i:])))
+	case -32:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(raw[4*i:])))
+	case -64:
+		return math.Float64frombits(binary.BigEndian.Uint64(raw[8*i:]))
+	}
+	return math.NaN()
+}
+
+// rawInt returns the i-th value of a big-endian integer FITS data array,
+// without conversion to float which would lose precision for BITPIX=64.
+func rawInt(raw []byte, bitpix, i int) int64 {
+	switch bitpix {
+	case 8:
+		return int64(raw[i])
+	case 16:
+		return int64(int16(binary.BigEndian.Uint16(raw[2*i:])))
+	case 32:
+		return int64(int32(binary.BigEndian.Uint32(raw[4*i:])))
+	case 64:
+		return int64(binary.BigEndian.Uint64(raw[8*i:]))
+	}
+	return 0
+}
+
+// headerFloat returns the numerical value of the header keyword key, or
+// def if the keyword is missing.
+func headerFloat(hdr *fitsio.Header, key string, def float64) float64 {
+	card := hdr.Get(key)
+	if card == nil {
+		return def
+	}
+	switch v := card.Value.(type) {
+	case float64:
+		return v
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	}
+	return def
+}
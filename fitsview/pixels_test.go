@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/saimn/fitsio"
+)
+
+func TestPixelValue(t *testing.T) {
+	raw := make([]byte, 16)
+	binary.BigEndian.PutUint16(raw[2:], uint16(0xfffe)) // -2
+	if got := pixelValue(raw, 16, 1); got != -2 {
+		t.Fatalf("int16: got=%v, want=-2", got)
+	}
+
+	binary.BigEndian.PutUint32(raw[4:], math.Float32bits(-1.5))
+	if got := pixelValue(raw, -32, 1); got != -1.5 {
+		t.Fatalf("float32: got=%v, want=-1.5", got)
+	}
+
+	binary.BigEndian.PutUint64(raw[8:], math.Float64bits(math.Pi))
+	if got := pixelValue(raw, -64, 1); got != math.Pi {
+		t.Fatalf("float64: got=%v, want=pi", got)
+	}
+}
+
+func TestDecodeImage(t *testing.T) {
+	hdr := fitsio.NewHeader([]fitsio.Card{
+		{Name: "BSCALE", Value: 2.0},
+		{Name: "BZERO", Value: 32768},
+		{Name: "BLANK", Value: -32768},
+	}, fitsio.IMAGE_HDU, 16, []int{2, 2})
+	df := newDataFormat(hdr)
+
+	// -32768 is blank, 0xffff is -1.
+	raw := []byte{0x80, 0x00, 0xff, 0xff, 0x00, 0x03, 0x00, 0x04}
+	img, err := decodeImage(raw, 2, 2, df)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !math.IsNaN(img.Data[0]) {
+		t.Fatalf("blank pixel: got=%v, want=NaN", img.Data[0])
+	}
+	for i, want := range []float64{32766, 32774, 32776} {
+		if got := img.Data[i+1]; got != want {
+			t.Fatalf("pixel %d: got=%v, want=%v", i+1, got, want)
+		}
+	}
+	if v, ok := img.value(1, 1); !ok || v != 32776 {
+		t.Fatalf("value(1, 1): got=(%v, %v), want=(32776, true)", v, ok)
+	}
+	if _, ok := img.value(2, 0); ok {
+		t.Fatalf("value(2, 0) is outside of the image")
+	}
+
+	nan := make([]byte, 8)
+	binary.BigEndian.PutUint32(nan, math.Float32bits(float32(math.NaN())))
+	binary.BigEndian.PutUint32(nan[4:], math.Float32bits(1.25))
+	img, err = decodeImage(nan, 2, 1, dataFormat{bitpix: -32, bscale: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !math.IsNaN(img.Data[0]) || img.Data[1] != 1.25 {
+		t.Fatalf("float32 data: got=%v", img.Data)
+	}
+
+	if _, err := decodeImage(raw, 3, 3, df); err == nil {
+		t.Fatalf("expected an error for a truncated array")
+	}
+	if _, err := decodeImage(raw, 1, 1, dataFormat{bitpix: 12}); err == nil {
+		t.Fatalf("expected an error for an invalid BITPIX")
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+
+	"github.com/gotk3/gotk3/cairo"
+	"github.com/gotk3/gotk3/gtk"
+)
+
+// plotView holds the widgets of a window plotting y against x.
+type plotView struct {
+	*gtk.Window
+	area *gtk.DrawingArea
+	note *gtk.Label
+
+	xlabel, ylabel string
+	x, y           []float64
+	points         bool // draw points instead of a line
+}
+
+func plotWindow(parent *gtk.ApplicationWindow) *plotView {
+	win, err := gtk.WindowNew(gtk.WINDOW_TOPLEVEL)
+	if err != nil {
+		log.Fatal("Unable to create window:", err)
+	}
+	win.SetTransientFor(parent)
+	win.SetDefaultSize(500, 350)
+	win.HideOnDelete()
+
+	vbox, _ := gtk.BoxNew(gtk.ORIENTATION_VERTICAL, 0)
+	win.Add(vbox)
+
+	area, err := gtk.DrawingAreaNew()
+	if err != nil {
+		log.Fatal("Unable to create drawing area:", err)
+	}
+	area.SetSizeRequest(300, 200)
+	vbox.PackStart(area, true, true, 0)
+
+	note, err := gtk.LabelNew("")
+	if err != nil {
+		log.Fatal("Unable to create label:", err)
+	}
+	note.SetSelectable(true)
+	vbox.PackStart(note, false, false, 5)
+
+	p := &plotView{Window: win, area: area, note: note}
+	area.Connect("draw", p.draw)
+	vbox.ShowAll()
+	return p
+}
+
+// plot shows y against x, with the axis labels and a note below the plot,
+// and presents the window. NaN values are not drawn.
+func (p *plotView) plot(title, xlabel, ylabel string, x, y []float64, points bool, note string) {
+	p.SetTitle(title)
+	p.xlabel, p.ylabel = xlabel, ylabel
+	p.x, p.y, p.points = x, y, points
+	p.note.SetText(note)
+	p.area.QueueDraw()
+	p.Present()
+}
+
+// dataRange returns the range of the values which are not NaN, or false
+// if there are none.
+func dataRange(values []float64) (lo, hi float64, ok bool) {
+	lo, hi = math.Inf(1), math.Inf(-1)
+	for _, v := range values {
+		if !math.IsNaN(v) {
+			lo, hi = math.Min(lo, v), math.Max(hi, v)
+		}
+	}
+	if lo > hi {
+		return 0, 0, false
+	}
+	if lo == hi {
+		lo, hi = lo-0.5, hi+0.5
+	}
+	return lo, hi, true
+}
+
+func (p *plotView) draw(da *gtk.DrawingArea, cr *cairo.Context) {
+	width, height := float64(da.GetAllocatedWidth()), float64(da.GetAllocatedHeight())
+	cr.SetSourceRGB(1, 1, 1)
+	cr.Paint()
+
+	xlo, xhi, okx := dataRange(p.x)
+	ylo, yhi, oky := dataRange(p.y)
+	if !okx || !oky {
+		return
+	}
+	const left, right, top, bottom = 60, 15, 15, 35
+	pw, ph := width-left-right, height-top-bottom
+	toWin := func(x, y float64) (float64, float64) {
+		return left + (x-xlo)/(xhi-xlo)*pw, top + (yhi-y)/(yhi-ylo)*ph
+	}
+
+	// Axes, with their limits and labels.
+	cr.SetSourceRGB(0, 0, 0)
+	cr.SetLineWidth(1)
+	cr.Rectangle(left, top, pw, ph)
+	cr.Stroke()
+	cr.SetFontSize(11)
+	for _, t := range []struct {
+		x, y float64
+		text string
+	}{
+		{left, height - bottom + 14, fmt.Sprintf("%.4g", xlo)},
+		{width - right - 40, height - bottom + 14, fmt.Sprintf("%.4g", xhi)},
+		{left + pw/2 - 20, height - 5, p.xlabel},
+		{2, height - bottom, fmt.Sprintf("%.4g", ylo)},
+		{2, top + 10, fmt.Sprintf("%.4g", yhi)},
+		{2, top + ph/2, p.ylabel},
+	} {
+		cr.MoveTo(t.x, t.y)
+		cr.ShowText(t.text)
+	}
+
+	cr.SetSourceRGB(0.2, 0.3, 0.8)
+	if p.points {
+		for i, x := range p.x {
+			if math.IsNaN(p.y[i]) {
+				continue
+			}
+			wx, wy := toWin(x, p.y[i])
+			cr.Rectangle(wx-1.5, wy-1.5, 3, 3)
+		}
+		cr.Fill()
+		return
+	}
+	cr.SetLineWidth(1.5)
+	started := false
+	for i, x := range p.x {
+		if math.IsNaN(p.y[i]) {
+			started = false
+			continue
+		}
+		wx, wy := toWin(x, p.y[i])
+		if started {
+			cr.LineTo(wx, wy)
+		} else {
+			cr.MoveTo(wx, wy)
+			started = true
+		}
+	}
+	cr.Stroke()
+}
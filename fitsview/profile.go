@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"gonum.org/v1/gonum/stat"
+)
+
+// lineProfile returns the pixel values along the segment from p0 to p1,
+// sampled every pixel, with their distance to p0. Blank pixels and
+// pixels out of the image are NaN.
+func lineProfile(f *floatImage, p0, p1 fpoint) (dist, values []float64) {
+	length := math.Hypot(p1.x-p0.x, p1.y-p0.y)
+	n := int(math.Floor(length)) + 1
+	for i := 0; i < n; i++ {
+		t := 0.0
+		if length > 0 {
+			t = float64(i) / length
+		}
+		x := p0.x + t*(p1.x-p0.x)
+		y := p0.y + t*(p1.y-p0.y)
+		v, ok := f.value(int(math.Floor(x)), int(math.Floor(y)))
+		if !ok {
+			v = math.NaN()
+		}
+		dist = append(dist, float64(i))
+		values = append(values, v)
+	}
+	return dist, values
+}
+
+// starProfile describes the radial profile of a star.
+type starProfile struct {
+	centroid   fpoint  // centroid, in image coordinates
+	peak       float64 // brightest pixel value
+	background float64 // median of the pixels around the star
+	fwhm       float64 // full width at half maximum, in pixels
+
+	// Distance of the pixels to the centroid, sorted, and their value.
+	radius, values []float64
+}
+
+// radialProfile measures the star near p, within radius pixels of its
+// centroid. The star is the brightest pixel within 3 pixels of p, the
+// background is estimated in an annulus of 4 pixels around the radius.
+func radialProfile(f *floatImage, p fpoint, radius int) (starProfile, error) {
+	var prof starProfile
+	px, py := int(math.Floor(p.x)), int(math.Floor(p.y))
+	bx, by := -1, -1
+	prof.peak = math.Inf(-1)
+	for y := py - 3; y <= py+3; y++ {
+		for x := px - 3; x <= px+3; x++ {
+			if v, ok := f.value(x, y); ok && !math.IsNaN(v) && v > prof.peak {
+				prof.peak, bx, by = v, x, y
+			}
+		}
+	}
+	if bx < 0 {
+		return prof, fmt.Errorf("no valid pixel at %v", p)
+	}
+
+	// Background in the annulus around the star.
+	var ring []float64
+	c := fpoint{float64(bx) + 0.5, float64(by) + 0.5}
+	r0, r1 := float64(radius), float64(radius+4)
+	forPixels(f, c, r1, func(x, y int, r, v float64) {
+		if r >= r0 {
+			ring = append(ring, v)
+		}
+	})
+	if len(ring) == 0 {
+		return prof, fmt.Errorf("no background pixel around %v", c)
+	}
+	sort.Float64s(ring)
+	prof.background = stat.Quantile(0.5, stat.Empirical, ring, nil)
+	amp := prof.peak - prof.background
+	if amp <= 0 {
+		return prof, fmt.Errorf("no star at %v", c)
+	}
+
+	// Centroid of the pixels above the background.
+	for i := 0; i < 3; i++ {
+		var sx, sy, sw float64
+		forPixels(f, c, r0, func(x, y int, r, v float64) {
+			if w := v - prof.background; w > 0 {
+				sx += w * (float64(x) + 0.5)
+				sy += w * (float64(y) + 0.5)
+				sw += w
+			}
+		})
+		c = fpoint{sx / sw, sy / sw}
+	}
+	prof.centroid = c
+
+	type sample struct{ r, v float64 }
+	var samples []sample
+	forPixels(f, c, r0, func(x, y int, r, v float64) {
+		samples = append(samples, sample{r, v})
+	})
+	sort.Slice(samples, func(i, j int) bool { return samples[i].r < samples[j].r })
+	for _, s := range samples {
+		prof.radius = append(prof.radius, s.r)
+		prof.values = append(prof.values, s.v)
+	}
+
+	// Half maximum crossing of the profile averaged in bins of half a
+	// pixel, starting from the peak at the centre.
+	const binWidth = 0.5
+	half := amp / 2
+	prevR, prevV := 0.0, amp
+	for lo := 0; lo < len(samples); {
+		hi := lo
+		bin := math.Floor(samples[lo].r / binWidth)
+		var sum float64
+		for ; hi < len(samples) && math.Floor(samples[hi].r/binWidth) == bin; hi++ {
+			sum += samples[hi].v - prof.background
+		}
+		r, v := (bin+0.5)*binWidth, sum/float64(hi-lo)
+		if v < half {
+			if prevV > v {
+				r = prevR + (r-prevR)*(prevV-half)/(prevV-v)
+			}
+			prof.fwhm = 2 * r
+			return prof, nil
+		}
+		prevR, prevV = r, v
+		lo = hi
+	}
+	return prof, fmt.Errorf("profile does not reach half maximum within %d pixels", radius)
+}
+
+// forPixels calls fn with the defined pixels whose centre is within
+// radius of c, with their distance to c.
+func forPixels(f *floatImage, c fpoint, radius float64, fn func(x, y int, r, v float64)) {
+	x0, x1 := int(math.Floor(c.x-radius)), int(math.Ceil(c.x+radius))
+	y0, y1 := int(math.Floor(c.y-radius)), int(math.Ceil(c.y+radius))
+	for y := y0; y <= y1; y++ {
+		for x := x0; x <= x1; x++ {
+			v, ok := f.value(x, y)
+			if !ok || math.IsNaN(v) {
+				continue
+			}
+			if r := math.Hypot(float64(x)+0.5-c.x, float64(y)+0.5-c.y); r <= radius {
+				fn(x, y, r, v)
+			}
+		}
+	}
+}
+
+// writeLineCSV writes a line profile from p0 to p1 as CSV, with the FITS
+// coordinates of the samples.
+func writeLineCSV(w io.Writer, p0, p1 fpoint, dist, values []float64) error {
+	length := math.Hypot(p1.x-p0.x, p1.y-p0.y)
+	if _, err := fmt.Fprintln(w, "distance,x,y,value"); err != nil {
+		return err
+	}
+	for i, d := range dist {
+		x, y := p0.x+0.5, p0.y+0.5
+		if length > 0 {
+			x += d / length * (p1.x - p0.x)
+			y += d / length * (p1.y - p0.y)
+		}
+		if _, err := fmt.Fprintf(w, "%g,%.2f,%.2f,%g\n", d, x, y, values[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeProfileCSV writes a radial profile as CSV, preceded by a comment
+// line with the FITS coordinates of the centroid and the measurements.
+func writeProfileCSV(w io.Writer, prof starProfile) error {
+	_, err := fmt.Fprintf(w, "# x=%.2f y=%.2f peak=%g background=%g fwhm=%.2f\nradius,value\n",
+		prof.centroid.x+0.5, prof.centroid.y+0.5, prof.peak, prof.background, prof.fwhm)
+	if err != nil {
+		return err
+	}
+	for i, r := range prof.radius {
+		if _, err := fmt.Fprintf(w, "%.3f,%g\n", r, prof.values[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+)
+
+// gaussianStar returns an image with a Gaussian star of standard
+// deviation sigma centred on c, over a background of 10.
+func gaussianStar(width, height int, c fpoint, sigma float64) *floatImage {
+	f := &floatImage{Data: make([]float64, width*height), Width: width, Height: height}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			r := math.Hypot(float64(x)+0.5-c.x, float64(y)+0.5-c.y)
+			f.Data[y*width+x] = 10 + 1000*math.Exp(-r*r/(2*sigma*sigma))
+		}
+	}
+	return f
+}
+
+func TestLineProfile(t *testing.T) {
+	f := &floatImage{Data: []float64{1, 2, 3, math.NaN(), 5, 6}, Width: 3, Height: 2}
+	dist, values := lineProfile(f, fpoint{0.5, 0.5}, fpoint{3.5, 1.5})
+	if len(dist) != 4 || dist[3] != 3 {
+		t.Fatalf("invalid distances: %v", dist)
+	}
+	if values[0] != 1 || values[1] != 2 || values[2] != 6 || !math.IsNaN(values[3]) {
+		t.Fatalf("invalid values: %v", values)
+	}
+
+	var buf bytes.Buffer
+	if err := writeLineCSV(&buf, fpoint{0.5, 0.5}, fpoint{2.5, 0.5}, []float64{0, 1, 2}, []float64{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	want := "distance,x,y,value\n0,1.00,1.00,1\n1,2.00,1.00,2\n2,3.00,1.00,3\n"
+	if got := buf.String(); got != want {
+		t.Fatalf("got=%q, want=%q", got, want)
+	}
+}
+
+func TestRadialProfile(t *testing.T) {
+	sigma := 2.0
+	c := fpoint{20.3, 19.6}
+	f := gaussianStar(41, 41, c, sigma)
+
+	// Click a few pixels away from the star.
+	prof, err := radialProfile(f, fpoint{22, 18}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(prof.centroid.x-c.x) > 0.05 || math.Abs(prof.centroid.y-c.y) > 0.05 {
+		t.Fatalf("got centroid=%v, want=%v", prof.centroid, c)
+	}
+	if math.Abs(prof.background-10) > 1 {
+		t.Fatalf("got background=%v, want=10", prof.background)
+	}
+	if want := 2 * math.Sqrt(2*math.Ln2) * sigma; math.Abs(prof.fwhm-want) > 0.3 {
+		t.Fatalf("got fwhm=%v, want=%v", prof.fwhm, want)
+	}
+	if len(prof.radius) == 0 || prof.radius[0] > prof.radius[len(prof.radius)-1] {
+		t.Fatalf("invalid profile radii: %v", prof.radius)
+	}
+
+	var buf bytes.Buffer
+	if err := writeProfileCSV(&buf, prof); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(buf.String(), "\n"); !strings.HasPrefix(lines[0], "# x=20.80 y=20.10") || lines[1] != "radius,value" {
+		t.Fatalf("invalid CSV header: %q", lines[:2])
+	}
+
+	// Flat image.
+	flat := &floatImage{Data: make([]float64, 30*30), Width: 30, Height: 30}
+	if _, err := radialProfile(flat, fpoint{15, 15}, 5); err == nil {
+		t.Fatalf("expected an error on a flat image")
+	}
+}
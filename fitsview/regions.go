@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/saimn/margo/fitsview/wcs"
+)
+
+// region is a shape drawn over the images. Coordinates are FITS pixel
+// coordinates, the centre of the first pixel being (1, 1), or RA and Dec
+// in degrees for sky regions. Sizes are in pixels, or in degrees for sky
+// regions. Angles are counter-clockwise from the first image axis.
+type region struct {
+	shape  string    // one of regionShapes
+	sky    bool      // coordinates and sizes are on the sky
+	coords []float64 // centre, or vertices of a polygon, as x, y pairs
+	size   []float64 // radius of a circle, radii of an ellipse, width and height of a box
+	angle  float64   // rotation of an ellipse or a box, in degrees
+	text   string
+}
+
+// regionShapes lists the supported shapes.
+var regionShapes = []string{"circle", "ellipse", "box", "polygon", "point"}
+
+// fpoint is a position in image coordinates, where the pixel (i, j)
+// covers [i, i+1) x [j, j+1).
+type fpoint struct {
+	x, y float64
+}
+
+var (
+	shapeRegexp = regexp.MustCompile(`^[-+]?\s*([a-z]+)\s*\((.*)\)$`)
+	textRegexp  = regexp.MustCompile(`text\s*=\s*[{"]([^}"]*)[}"]`)
+)
+
+// parseRegions reads the shapes of a DS9 region file in image, physical,
+// fk5, icrs or j2000 coordinates.
+func parseRegions(r io.Reader) ([]region, error) {
+	var regions []region
+	sky, system := false, ""
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Text()
+		text := ""
+		if i := strings.Index(line, "#"); i >= 0 {
+			if m := textRegexp.FindStringSubmatch(line[i:]); m != nil {
+				text = m[1]
+			}
+			line = line[:i]
+		}
+		for _, stmt := range strings.Split(line, ";") {
+			stmt = strings.TrimSpace(stmt)
+			switch lower := strings.ToLower(stmt); {
+			case stmt == "", strings.HasPrefix(lower, "global"):
+				continue
+			case lower == "image" || lower == "physical":
+				sky, system = false, lower
+				continue
+			case lower == "fk5" || lower == "icrs" || lower == "j2000":
+				sky, system = true, lower
+				continue
+			case !strings.Contains(stmt, "("):
+				return nil, fmt.Errorf("line %d: unsupported coordinate system %q", n, stmt)
+			}
+			if system == "" {
+				return nil, fmt.Errorf("line %d: missing coordinate system", n)
+			}
+			reg, err := parseShape(stmt, sky)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", n, err)
+			}
+			reg.text = text
+			regions = append(regions, reg)
+		}
+	}
+	return regions, sc.Err()
+}
+
+// parseShape parses a shape such as "circle(100,100,20)".
+func parseShape(s string, sky bool) (region, error) {
+	m := shapeRegexp.FindStringSubmatch(s)
+	if m == nil {
+		return region{}, fmt.Errorf("invalid shape %q", s)
+	}
+	reg := region{shape: m[1], sky: sky}
+	args := strings.FieldsFunc(m[2], func(r rune) bool { return r == ',' || r == ' ' })
+
+	var ncoords, nsize int
+	switch reg.shape {
+	case "circle":
+		ncoords, nsize = 2, 1
+	case "ellipse":
+		ncoords, nsize = 2, 2
+	case "box":
+		ncoords, nsize = 2, 2
+	case "point":
+		ncoords = 2
+	case "polygon":
+		ncoords = len(args)
+		if ncoords < 6 || ncoords%2 != 0 {
+			return region{}, fmt.Errorf("invalid polygon %q", s)
+		}
+	default:
+		return region{}, fmt.Errorf("unsupported shape %q", reg.shape)
+	}
+	nangle := 0
+	if reg.shape == "ellipse" || reg.shape == "box" {
+		nangle = 1
+	}
+	if len(args) != ncoords+nsize && len(args) != ncoords+nsize+nangle {
+		return region{}, fmt.Errorf("invalid number of parameters in %q", s)
+	}
+
+	for i := 0; i < ncoords; i++ {
+		v, err := parseCoord(args[i], i%2 == 0, sky)
+		if err != nil {
+			return region{}, err
+		}
+		reg.coords = append(reg.coords, v)
+	}
+	for _, arg := range args[ncoords : ncoords+nsize] {
+		v, err := parseSize(arg, sky)
+		if err != nil {
+			return region{}, err
+		}
+		reg.size = append(reg.size, v)
+	}
+	if len(args) > ncoords+nsize {
+		v, err := strconv.ParseFloat(args[ncoords+nsize], 64)
+		if err != nil {
+			return region{}, fmt.Errorf("invalid angle %q", args[ncoords+nsize])
+		}
+		reg.angle = v
+	}
+	return reg, nil
+}
+
+func parseCoord(s string, ra, sky bool) (float64, error) {
+	switch {
+	case !sky:
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid coordinate %q", s)
+		}
+		return v, nil
+	case ra:
+		return wcs.ParseRA(s)
+	}
+	return wcs.ParseDec(s)
+}
+
+// parseSize parses a size in pixels, or in degrees for sky regions. Sky
+// sizes can be given in arcseconds ("), arcminutes (') or degrees (d).
+func parseSize(s string, sky bool) (float64, error) {
+	unit := 0.0
+	switch {
+	case strings.HasSuffix(s, `"`):
+		unit = 1.0 / 3600
+	case strings.HasSuffix(s, "'"):
+		unit = 1.0 / 60
+	case strings.HasSuffix(s, "d"):
+		unit = 1
+	}
+	if unit == 0 {
+		unit = 1
+	} else if !sky {
+		return 0, fmt.Errorf("angular size %q in image coordinates", s)
+	} else {
+		s = s[:len(s)-1]
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return v * unit, nil
+}
+
+// writeRegions writes the regions as a DS9 region file.
+func writeRegions(w io.Writer, regions []region) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "# Region file format: DS9 version 4.1")
+	fmt.Fprintln(bw, "global color=green")
+	system := ""
+	for _, reg := range regions {
+		sys := "image"
+		if reg.sky {
+			sys = "fk5"
+		}
+		if sys != system {
+			fmt.Fprintln(bw, sys)
+			system = sys
+		}
+		var args []string
+		for _, v := range reg.coords {
+			if reg.sky {
+				args = append(args, strconv.FormatFloat(v, 'f', 7, 64))
+			} else {
+				args = append(args, strconv.FormatFloat(v, 'g', -1, 64))
+			}
+		}
+		for _, v := range reg.size {
+			if reg.sky {
+				args = append(args, strconv.FormatFloat(v*3600, 'f', 4, 64)+`"`)
+			} else {
+				args = append(args, strconv.FormatFloat(v, 'g', -1, 64))
+			}
+		}
+		if reg.shape == "ellipse" || reg.shape == "box" {
+			args = append(args, strconv.FormatFloat(reg.angle, 'g', -1, 64))
+		}
+		fmt.Fprintf(bw, "%s(%s)", reg.shape, strings.Join(args, ","))
+		if reg.text != "" {
+			fmt.Fprintf(bw, " # text={%s}", reg.text)
+		}
+		fmt.Fprintln(bw)
+	}
+	return bw.Flush()
+}
+
+// outline returns the vertices of the region in image coordinates, a
+// single vertex for a point. ok is false for sky regions when the image
+// has no WCS.
+func (reg region) outline(w *wcs.WCS) (pts []fpoint, ok bool) {
+	scale := 1.0
+	var centre []fpoint
+	for i := 0; i+1 < len(reg.coords); i += 2 {
+		x, y := reg.coords[i], reg.coords[i+1]
+		if reg.sky {
+			if w == nil {
+				return nil, false
+			}
+			if x, y, ok = w.WorldToPixel(x, y); !ok {
+				return nil, false
+			}
+		}
+		// FITS coordinates start at 1, at the centre of the first pixel.
+		centre = append(centre, fpoint{x - 0.5, y - 0.5})
+	}
+	if reg.sky {
+		scale = 1 / w.PixelScale()
+	}
+
+	c := centre[0]
+	sin, cos := math.Sincos(reg.angle * math.Pi / 180)
+	rotate := func(dx, dy float64) fpoint {
+		return fpoint{c.x + dx*cos - dy*sin, c.y + dx*sin + dy*cos}
+	}
+
+	switch reg.shape {
+	case "circle", "ellipse":
+		rx := reg.size[0] * scale
+		ry := rx
+		if reg.shape == "ellipse" {
+			ry = reg.size[1] * scale
+		}
+		const n = 72
+		for i := 0; i < n; i++ {
+			t := 2 * math.Pi * float64(i) / n
+			pts = append(pts, rotate(rx*math.Cos(t), ry*math.Sin(t)))
+		}
+	case "box":
+		dx, dy := reg.size[0]*scale/2, reg.size[1]*scale/2
+		pts = []fpoint{rotate(-dx, -dy), rotate(dx, -dy), rotate(dx, dy), rotate(-dx, dy)}
+	default:
+		pts = centre
+	}
+	return pts, true
+}
+
+// contains reports whether the image position p is inside the region,
+// or within tol pixels of a point.
+func (reg region) contains(w *wcs.WCS, p fpoint, tol float64) bool {
+	pts, ok := reg.outline(w)
+	if !ok {
+		return false
+	}
+	if reg.shape == "point" {
+		return math.Hypot(p.x-pts[0].x, p.y-pts[0].y) <= tol
+	}
+	// Even-odd rule.
+	in := false
+	for i, j := 0, len(pts)-1; i < len(pts); j, i = i, i+1 {
+		a, b := pts[i], pts[j]
+		if (a.y > p.y) != (b.y > p.y) && p.x < (b.x-a.x)*(p.y-a.y)/(b.y-a.y)+a.x {
+			in = !in
+		}
+	}
+	return in
+}
+
+// newRegion returns the region of the given shape drawn with the mouse
+// through the image positions pts: centre and a point on the circle,
+// opposite corners of the bounding box of an ellipse or a box, vertices
+// of a polygon. The region is on the sky when w is not nil.
+func newRegion(shape string, pts []fpoint, w *wcs.WCS) region {
+	reg := region{shape: shape}
+	a := pts[0]
+	b := pts[len(pts)-1]
+	switch shape {
+	case "circle":
+		reg.coords = []float64{a.x, a.y}
+		reg.size = []float64{math.Hypot(b.x-a.x, b.y-a.y)}
+	case "ellipse", "box":
+		reg.coords = []float64{(a.x + b.x) / 2, (a.y + b.y) / 2}
+		reg.size = []float64{math.Abs(b.x - a.x), math.Abs(b.y - a.y)}
+		if shape == "ellipse" {
+			reg.size[0] /= 2
+			reg.size[1] /= 2
+		}
+	case "polygon":
+		for _, p := range pts {
+			reg.coords = append(reg.coords, p.x, p.y)
+		}
+	default:
+		reg.coords = []float64{a.x, a.y}
+	}
+
+	for i := range reg.coords {
+		reg.coords[i] += 0.5
+	}
+	if w != nil {
+		reg.sky = true
+		for i := 0; i < len(reg.coords); i += 2 {
+			reg.coords[i], reg.coords[i+1] = w.PixelToWorld(reg.coords[i], reg.coords[i+1])
+		}
+		for i := range reg.size {
+			reg.size[i] *= w.PixelScale()
+		}
+	}
+	return reg
+}
+
+// nextRegionShape returns the shape drawn with the mouse after shape,
+// the empty string meaning that the mouse pans the image.
+func nextRegionShape(shape string) string {
+	if shape == "" {
+		return regionShapes[0]
+	}
+	for i, s := range regionShapes {
+		if s == shape && i+1 < len(regionShapes) {
+			return regionShapes[i+1]
+		}
+	}
+	return ""
+}
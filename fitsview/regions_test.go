@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/saimn/fitsio"
+	"github.com/saimn/margo/fitsview/wcs"
+)
+
+func TestParseRegions(t *testing.T) {
+	const file = `# Region file format: DS9 version 4.1
+global color=green dashlist=8 3 width=1 font="helvetica 10 normal roman"
+image
+circle(100,100,20) # text={star}
+box(50,60,10,20,30)
+-ellipse(10,20,3,4)
+physical; point(5,6) # point=cross
+polygon(1,1,10,1,10,10)
+fk5
+circle(13:29:52.7,+47:11:43,30") # color=red
+ellipse(202.5,47.2,1',2',45)
+`
+	got, err := parseRegions(strings.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []region{
+		{shape: "circle", coords: []float64{100, 100}, size: []float64{20}, text: "star"},
+		{shape: "box", coords: []float64{50, 60}, size: []float64{10, 20}, angle: 30},
+		{shape: "ellipse", coords: []float64{10, 20}, size: []float64{3, 4}},
+		{shape: "point", coords: []float64{5, 6}},
+		{shape: "polygon", coords: []float64{1, 1, 10, 1, 10, 10}},
+		{shape: "circle", sky: true, coords: []float64{202.4695833, 47.1952778}, size: []float64{30.0 / 3600}},
+		{shape: "ellipse", sky: true, coords: []float64{202.5, 47.2}, size: []float64{1.0 / 60, 2.0 / 60}, angle: 45},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d regions, want %d", len(got), len(want))
+	}
+	for i := range want {
+		for j := range want[i].coords {
+			if math.Abs(got[i].coords[j]-want[i].coords[j]) < 1e-6 {
+				got[i].coords[j] = want[i].coords[j]
+			}
+		}
+		if !reflect.DeepEqual(got[i], want[i]) {
+			t.Fatalf("region %d\ngot =%+v\nwant=%+v\n", i, got[i], want[i])
+		}
+	}
+
+	for _, bad := range []string{
+		"circle(1,2,3)",
+		"image\ncircle(1,2)",
+		"image\ncircle(1,2,3\")",
+		"galactic\ncircle(1,2,3)",
+		"image\nannulus(1,2,3,4)",
+		"image\npolygon(1,2,3,4)",
+	} {
+		if _, err := parseRegions(strings.NewReader(bad)); err == nil {
+			t.Fatalf("expected an error for %q", bad)
+		}
+	}
+}
+
+func TestWriteRegions(t *testing.T) {
+	regions := []region{
+		{shape: "circle", coords: []float64{100.5, 100}, size: []float64{20}, text: "star"},
+		{shape: "box", sky: true, coords: []float64{202.5, 47.2}, size: []float64{10.0 / 3600, 20.0 / 3600}, angle: 30},
+		{shape: "point", coords: []float64{5, 6}},
+	}
+	var buf bytes.Buffer
+	if err := writeRegions(&buf, regions); err != nil {
+		t.Fatal(err)
+	}
+	want := `# Region file format: DS9 version 4.1
+global color=green
+image
+circle(100.5,100,20) # text={star}
+fk5
+box(202.5000000,47.2000000,10.0000",20.0000",30)
+image
+point(5,6)
+`
+	if got := buf.String(); got != want {
+		t.Fatalf("invalid region file\ngot =%q\nwant=%q\n", got, want)
+	}
+
+	back, err := parseRegions(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(back) != len(regions) || back[1].size[1] != regions[1].size[1] {
+		t.Fatalf("invalid round trip: %+v", back)
+	}
+}
+
+func TestRegionOutline(t *testing.T) {
+	box := region{shape: "box", coords: []float64{10.5, 20.5}, size: []float64{4, 2}, angle: 90}
+	pts, ok := box.outline(nil)
+	if !ok || len(pts) != 4 {
+		t.Fatalf("invalid box outline: %v", pts)
+	}
+	// Rotated by 90 degrees, the box is 2 pixels wide and 4 pixels high.
+	if math.Abs(pts[0].x-11) > 1e-9 || math.Abs(pts[0].y-18) > 1e-9 {
+		t.Fatalf("invalid first corner: %v, want {11 18}", pts[0])
+	}
+	for _, table := range []struct {
+		p    fpoint
+		want bool
+	}{
+		{fpoint{10, 20}, true},
+		{fpoint{10.5, 21.5}, true},
+		{fpoint{12, 20}, false},
+	} {
+		if got := box.contains(nil, table.p, 1); got != table.want {
+			t.Fatalf("contains(%v): got=%v, want=%v", table.p, got, table.want)
+		}
+	}
+
+	sky := region{shape: "circle", sky: true, coords: []float64{0, 0}, size: []float64{0.2}}
+	if _, ok := sky.outline(nil); ok {
+		t.Fatalf("sky region drawn without WCS")
+	}
+}
+
+func TestNewRegion(t *testing.T) {
+	hdr := fitsio.NewHeader([]fitsio.Card{
+		{Name: "CTYPE1", Value: "RA---TAN"},
+		{Name: "CTYPE2", Value: "DEC--TAN"},
+		{Name: "CRPIX1", Value: 50.5},
+		{Name: "CRPIX2", Value: 50.5},
+		{Name: "CRVAL1", Value: 10.0},
+		{Name: "CRVAL2", Value: 20.0},
+		{Name: "CDELT1", Value: -0.001},
+		{Name: "CDELT2", Value: 0.001},
+	}, fitsio.IMAGE_HDU, -32, []int{100, 100})
+	w, err := wcs.New(hdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pts := []fpoint{{50, 50}, {53, 54}}
+	reg := newRegion("circle", pts, nil)
+	if !reflect.DeepEqual(reg, region{shape: "circle", coords: []float64{50.5, 50.5}, size: []float64{5}}) {
+		t.Fatalf("invalid circle: %+v", reg)
+	}
+	reg = newRegion("box", pts, nil)
+	if !reflect.DeepEqual(reg.coords, []float64{52, 52.5}) || !reflect.DeepEqual(reg.size, []float64{3, 4}) {
+		t.Fatalf("invalid box: %+v", reg)
+	}
+
+	// A sky region is drawn back at the same place.
+	reg = newRegion("circle", pts, w)
+	if !reg.sky || math.Abs(reg.coords[0]-10) > 1e-9 || math.Abs(reg.coords[1]-20) > 1e-9 ||
+		math.Abs(reg.size[0]-0.005) > 1e-12 {
+		t.Fatalf("invalid sky circle: %+v", reg)
+	}
+	outline, ok := reg.outline(w)
+	if !ok || math.Abs(outline[0].x-55) > 1e-6 || math.Abs(outline[0].y-50) > 1e-6 {
+		t.Fatalf("invalid sky circle outline: %v", outline[:1])
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"image"
+	"math"
+)
+
+// stretchImage applies the stretch between vmin and vmax to the picture
+// and returns the result as an RGBA image coloured with cmap, where
+// blank pixels are transparent.
+// It does not depend on GTK so it can be used for headless rendering.
+func stretchImage(picture *floatImage, vmin, vmax float64, stretch Stretch, cmap *Colormap) *image.RGBA {
+	width := picture.Width
+	height := picture.Height
+	rgba := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	const bytesPerPixel = 4
+	for i, v := range picture.Data[:width*height] {
+		if math.IsNaN(v) {
+			continue
+		}
+
+		// scale
+		var val uint32
+		if v <= vmin {
+			val = 0
+		} else if v >= vmax {
+			val = maxUint32
+		} else {
+			val = uint32(stretch.Apply((v-vmin)/(vmax-vmin)) * maxUint32)
+		}
+		c := cmap.At(uint32ToByte(val))
+		j := i * bytesPerPixel
+		rgba.Pix[j] = c.R
+		rgba.Pix[j+1] = c.G
+		rgba.Pix[j+2] = c.B
+		rgba.Pix[j+3] = c.A
+	}
+
+	return rgba
+}
+
+func uint32ToByte(value uint32) byte {
+	const ratio = float64(256) / float64(65536)
+	byteValue := ratio * float64(value)
+	if byteValue > 255 {
+		return byte(255)
+	}
+	return byte(byteValue)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestStretchImage(t *testing.T) {
+	img := &floatImage{Data: []float64{0, 1000, 1500, 3000, math.NaN()}, Width: 5, Height: 1}
+
+	rgba := stretchImage(img, 1000, 2000, linearStretch{}, colormaps["gray"])
+	want := []byte{0, 0, 127, 255}
+	for x, w := range want {
+		c := rgba.RGBAAt(x, 0)
+		if c.R != w || c.G != w || c.B != w || c.A != 255 {
+			t.Fatalf("pixel %d: got=%v, want=%d", x, c, w)
+		}
+	}
+	if c := rgba.RGBAAt(4, 0); c.A != 0 {
+		t.Fatalf("blank pixel is not transparent: %v", c)
+	}
+}
+
+func TestExportName(t *testing.T) {
+	for _, table := range []struct {
+		fname string
+		hdu   int
+		want  string
+	}{
+		{fname: "ngc1316.fits", hdu: 0, want: "ngc1316_0.png"},
+		{fname: "/data/night/ngc.1316.fits.gz", hdu: 2, want: "ngc.1316_2.png"},
+		{fname: "http://example.org/img.fit", hdu: 1, want: "img_1.png"},
+	} {
+		got := exportName(table.fname, table.hdu)
+		if got != table.want {
+			t.Fatalf("invalid name\ngot =%q\nwant=%q\n", got, table.want)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+
+	"gonum.org/v1/gonum/stat"
+)
+
+// rgbModes lists the ways of combining the channels of a composite:
+// a stretch per channel, or the asinh stretch of the intensity described
+// by Lupton et al. (2004), which preserves the colours of bright objects.
+var rgbModes = []string{"channel", "lupton"}
+
+// rgbOptions describes how the channels of a composite are combined.
+type rgbOptions struct {
+	weights    [3]float64 // weights of the red, green and blue channels
+	qmin, qmax float64    // quantiles of the display limits of each channel
+	stretch    string     // stretch of each channel in the channel mode
+	mode       string     // one of rgbModes
+	q          float64    // softening of the Lupton stretch
+}
+
+// parseChannel parses an RGB channel given as a file name, followed by
+// an optional HDU index in brackets, e.g. "img.fits[2]". hdu is -1 when
+// no HDU is given.
+func parseChannel(spec string) (name string, hdu int, err error) {
+	if !strings.HasSuffix(spec, "]") {
+		return spec, -1, nil
+	}
+	i := strings.LastIndex(spec, "[")
+	if i < 0 {
+		return "", 0, fmt.Errorf("invalid channel %q", spec)
+	}
+	hdu, err = strconv.Atoi(spec[i+1 : len(spec)-1])
+	if err != nil || hdu < 0 {
+		return "", 0, fmt.Errorf("invalid HDU in channel %q", spec)
+	}
+	return spec[:i], hdu, nil
+}
+
+// parseWeights parses three comma-separated channel weights.
+func parseWeights(s string) ([3]float64, error) {
+	var w [3]float64
+	fields := strings.Split(s, ",")
+	if len(fields) != 3 {
+		return w, fmt.Errorf("invalid weights %q, want three values", s)
+	}
+	for i, f := range fields {
+		v, err := strconv.ParseFloat(strings.TrimSpace(f), 64)
+		if err != nil || v < 0 {
+			return w, fmt.Errorf("invalid weight %q", f)
+		}
+		w[i] = v
+	}
+	return w, nil
+}
+
+// processChannels scans the three channels of an RGB composite, one file
+// per channel with its selected image HDU (the first one by default).
+func processChannels(specs []string) ([]fileInfo, error) {
+	if len(specs) != 3 {
+		return nil, fmt.Errorf("an RGB composite needs three channels, got %d", len(specs))
+	}
+	infos := make([]fileInfo, 0, 3)
+	for _, spec := range specs {
+		desc, err := scanImage(spec)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, fileInfo{Name: spec, Images: []imageInfo{{
+			hduDesc: desc,
+			scale:   100,
+			fit:     true,
+			qmin:    0.01,
+			qmax:    0.99,
+		}}})
+	}
+
+	b := infos[0].Images[0].Bounds()
+	for _, finfo := range infos[1:] {
+		if finfo.Images[0].Bounds() != b {
+			return nil, fmt.Errorf("channels have different sizes: %s is %v, %s is %v",
+				infos[0].Name, b.Size(), finfo.Name, finfo.Images[0].Bounds().Size())
+		}
+	}
+	return infos, nil
+}
+
+// scanImage scans the image HDU given as a file name, followed by an
+// optional HDU index in brackets. The first image HDU is used when no
+// index is given.
+func scanImage(spec string) (hduDesc, error) {
+	name, hdu, err := parseChannel(spec)
+	if err != nil {
+		return hduDesc{}, err
+	}
+	r, err := openStream(name)
+	if err != nil {
+		return hduDesc{}, err
+	}
+	descs, err := scanHDUs(r, name)
+	r.Close()
+	if err != nil {
+		return hduDesc{}, err
+	}
+	for _, desc := range descs {
+		if hdu < 0 || desc.hdu == hdu {
+			return desc, nil
+		}
+	}
+	return hduDesc{}, fmt.Errorf("%s: no image", spec)
+}
+
+// loadChannels decodes the channels of an RGB composite, as returned by
+// processChannels.
+func loadChannels(infos []fileInfo) ([3]*imageInfo, error) {
+	var chans [3]*imageInfo
+	for c := range chans {
+		chans[c] = &infos[c].Images[0]
+		if err := images.load(chans[c]); err != nil {
+			return chans, err
+		}
+	}
+	return chans, nil
+}
+
+// rgbFlags returns the options of the RGB composite given on the command
+// line, with the display quantiles qmin and qmax.
+func rgbFlags(qmin, qmax float64) rgbOptions {
+	weights, _ := parseWeights(*rgbWeights)
+	return rgbOptions{
+		weights: weights,
+		qmin:    qmin,
+		qmax:    qmax,
+		stretch: *stretchName,
+		mode:    *rgbMode,
+		q:       *luptonQ,
+	}
+}
+
+// composeRGB combines three decoded images of the same size into a colour
+// image. Pixels blank in any channel are transparent.
+func composeRGB(chans [3]*imageInfo, opts rgbOptions) (*image.RGBA, error) {
+	width, height := chans[0].Width, chans[0].Height
+	for _, ch := range chans[1:] {
+		if ch.Width != width || ch.Height != height {
+			return nil, fmt.Errorf("channels have different sizes")
+		}
+	}
+
+	// Display limits of each channel.
+	var vmin, vmax [3]float64
+	for c, ch := range chans {
+		pixels := ch.sortedPixels()
+		if len(pixels) == 0 {
+			return nil, fmt.Errorf("channel %d has no valid pixel", c+1)
+		}
+		vmin[c] = stat.Quantile(opts.qmin, stat.Empirical, pixels, nil)
+		vmax[c] = stat.Quantile(opts.qmax, stat.Empirical, pixels, nil)
+	}
+
+	rgba := image.NewRGBA(image.Rect(0, 0, width, height))
+	var err error
+	switch opts.mode {
+	case "channel":
+		err = stretchChannels(rgba, chans, vmin, vmax, opts)
+	case "lupton":
+		luptonChannels(rgba, chans, vmin, vmax, opts)
+	default:
+		err = fmt.Errorf("unknown RGB mode %q", opts.mode)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rgba, nil
+}
+
+// stretchChannels stretches each channel between its display limits.
+func stretchChannels(rgba *image.RGBA, chans [3]*imageInfo, vmin, vmax [3]float64, opts rgbOptions) error {
+	var stretches [3]Stretch
+	for c, ch := range chans {
+		s, err := newStretch(opts.stretch, ch.sortedPixels(), vmin[c], vmax[c])
+		if err != nil {
+			return err
+		}
+		stretches[c] = s
+	}
+
+	for i := range chans[0].Data {
+		var px [3]float64
+		blank := false
+		for c, ch := range chans {
+			v := ch.Data[i]
+			if math.IsNaN(v) {
+				blank = true
+				break
+			}
+			x := 1.0
+			if vmax[c] > vmin[c] {
+				x = math.Min(math.Max((v-vmin[c])/(vmax[c]-vmin[c]), 0), 1)
+			}
+			px[c] = stretches[c].Apply(x) * opts.weights[c]
+		}
+		if blank {
+			continue
+		}
+		setPixel(rgba, i, px, 1)
+	}
+	return nil
+}
+
+// luptonChannels maps the intensity of the channels, their mean above
+// their lower display limit, with an asinh stretch, and scales the
+// channels by the same factor. The linear part of the stretch extends to
+// the upper display limit of the intensity.
+func luptonChannels(rgba *image.RGBA, chans [3]*imageInfo, vmin, vmax [3]float64, opts rgbOptions) {
+	q := opts.q
+	if q < 1e-7 {
+		q = 0.1
+	}
+	// Same mapping as astropy.visualization.make_lupton_rgb.
+	const frac = 0.1
+	slope := frac / math.Asinh(frac*q)
+
+	var stretch float64
+	for c := range chans {
+		stretch += (vmax[c] - vmin[c]) * opts.weights[c] / 3
+	}
+	if stretch <= 0 {
+		stretch = 1
+	}
+	soften := q / stretch
+
+	for i := range chans[0].Data {
+		var px [3]float64
+		var intensity float64
+		blank := false
+		for c, ch := range chans {
+			v := ch.Data[i]
+			if math.IsNaN(v) {
+				blank = true
+				break
+			}
+			px[c] = math.Max(v-vmin[c], 0) * opts.weights[c]
+			intensity += px[c] / 3
+		}
+		if blank {
+			continue
+		}
+		f := 0.0
+		if intensity > 0 {
+			f = math.Asinh(intensity*soften) * slope / intensity
+		}
+		for c := range px {
+			px[c] *= f
+		}
+		// Saturated pixels keep their colour.
+		setPixel(rgba, i, px, math.Max(px[0], math.Max(px[1], px[2])))
+	}
+}
+
+// setPixel sets the pixel i of rgba to the channel values px in [0, 1],
+// divided by max when max is above 1.
+func setPixel(rgba *image.RGBA, i int, px [3]float64, max float64) {
+	if max < 1 {
+		max = 1
+	}
+	j := i * 4
+	for c, v := range px {
+		rgba.Pix[j+c] = byte(math.Min(math.Max(v/max, 0), 1) * 255)
+	}
+	rgba.Pix[j+3] = 255
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseChannel(t *testing.T) {
+	for _, table := range []struct {
+		spec string
+		name string
+		hdu  int
+		ok   bool
+	}{
+		{"r.fits", "r.fits", -1, true},
+		{"r.fits.gz[2]", "r.fits.gz", 2, true},
+		{"r.fits[x]", "", 0, false},
+		{"r.fits]", "", 0, false},
+	} {
+		name, hdu, err := parseChannel(table.spec)
+		if (err == nil) != table.ok || name != table.name || hdu != table.hdu {
+			t.Fatalf("parseChannel(%q): got=(%q, %d, %v), want=(%q, %d)", table.spec, name, hdu, err, table.name, table.hdu)
+		}
+	}
+
+	if w, err := parseWeights("1, 0.5,2"); err != nil || w != [3]float64{1, 0.5, 2} {
+		t.Fatalf("parseWeights: got=(%v, %v)", w, err)
+	}
+	for _, bad := range []string{"1,2", "1,2,x", "1,-1,1"} {
+		if _, err := parseWeights(bad); err == nil {
+			t.Fatalf("parseWeights(%q): expected an error", bad)
+		}
+	}
+}
+
+func channel(data ...float64) *imageInfo {
+	return &imageInfo{
+		hduDesc: hduDesc{axes: []int{len(data), 1}, planes: 1},
+		hduData: &hduData{floatImage: &floatImage{Data: data, Width: len(data), Height: 1}},
+	}
+}
+
+func TestComposeRGB(t *testing.T) {
+	chans := [3]*imageInfo{
+		channel(0, 10, 20, 5),
+		channel(0, 0, 20, math.NaN()),
+		channel(100, 0, 200, 150),
+	}
+	opts := rgbOptions{weights: [3]float64{1, 1, 0.5}, qmin: 0, qmax: 1, stretch: "linear", mode: "channel"}
+	rgba, err := composeRGB(chans, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{
+		0, 0, 63, 255,
+		127, 0, 0, 255,
+		255, 255, 127, 255,
+		0, 0, 0, 0, // blank in the green channel
+	}
+	for i, v := range want {
+		if rgba.Pix[i] != v {
+			t.Fatalf("channel mode: got=%v, want=%v", rgba.Pix, want)
+		}
+	}
+
+	// The Lupton stretch keeps the colour ratios.
+	opts.mode, opts.q = "lupton", 8
+	opts.weights = [3]float64{1, 1, 1}
+	chans[1] = channel(0, 0, 20, 10)
+	rgba, err = composeRGB(chans, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if px := rgba.Pix[8:12]; px[0] != 24 || px[1] != 24 || px[2] != 241 {
+		t.Fatalf("invalid saturated pixel: %v", px)
+	}
+	if px := rgba.Pix[4:8]; px[1] != 0 || px[2] != 0 || px[0] == 0 {
+		t.Fatalf("invalid red pixel: %v", px)
+	}
+
+	opts.mode = "unknown"
+	if _, err := composeRGB(chans, opts); err == nil {
+		t.Fatalf("expected an error for an unknown mode")
+	}
+}
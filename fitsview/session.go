@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// session is the state of the viewer saved in a session file: the files,
+// the current image, the display settings and the regions. The stretch
+// and the colormap apply to all the images, as in the viewer, while the
+// zoom, the pan and the display limits are saved per image.
+type session struct {
+	Files    []string      `json:"files"`
+	Current  sessionCursor `json:"current"`
+	Stretch  string        `json:"stretch"`
+	Colormap string        `json:"colormap"`
+	Views    []sessionView `json:"views,omitempty"`
+	Regions  string        `json:"regions,omitempty"` // DS9 region file
+}
+
+// sessionCursor is the position of the cursor in a session.
+type sessionCursor struct {
+	File  string `json:"file"`
+	Image int    `json:"image"`
+	Plane int    `json:"plane"`
+}
+
+// sessionView is the view of an image HDU in a session.
+type sessionView struct {
+	File  string  `json:"file"`
+	HDU   int     `json:"hdu"`
+	Scale int     `json:"scale"`
+	X     int     `json:"x"`
+	Y     int     `json:"y"`
+	Fit   bool    `json:"fit"`
+	QMin  float64 `json:"qmin"`
+	QMax  float64 `json:"qmax"`
+}
+
+// newSession returns the session of the files infos, shown at the cursor
+// c with the given stretch, colormap and regions.
+func newSession(infos []fileInfo, c cursor, stretch, cmap string, regs []region) (*session, error) {
+	s := &session{Stretch: stretch, Colormap: cmap}
+	for i, finfo := range infos {
+		if len(finfo.Images) > 0 && finfo.Images[0].expr != nil {
+			// The result of -expr is not a file.
+			continue
+		}
+		name := sessionPath(finfo.Name)
+		s.Files = append(s.Files, name)
+		if i == c.file {
+			s.Current = sessionCursor{File: name, Image: c.img, Plane: c.plane}
+		}
+		for _, img := range finfo.Images {
+			s.Views = append(s.Views, sessionView{
+				File:  name,
+				HDU:   img.hdu,
+				Scale: img.scale,
+				X:     img.orig.X,
+				Y:     img.orig.Y,
+				Fit:   img.fit,
+				QMin:  img.qmin,
+				QMax:  img.qmax,
+			})
+		}
+	}
+	if len(regs) > 0 {
+		var buf bytes.Buffer
+		if err := writeRegions(&buf, regs); err != nil {
+			return nil, err
+		}
+		s.Regions = buf.String()
+	}
+	return s, nil
+}
+
+// sessionPath returns the absolute path of a local file, so that the
+// session can be restored from another directory.
+func sessionPath(name string) string {
+	if _, err := os.Stat(name); err != nil {
+		return name
+	}
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return name
+	}
+	return abs
+}
+
+// apply restores the views of the images of infos saved in the session,
+// and returns the saved cursor. The files missing from infos are ignored.
+func (s *session) apply(infos []fileInfo) cursor {
+	type key struct {
+		file string
+		hdu  int
+	}
+	views := make(map[key]sessionView)
+	for _, v := range s.Views {
+		views[key{v.File, v.HDU}] = v
+	}
+
+	var c cursor
+	for i := range infos {
+		finfo := &infos[i]
+		name := sessionPath(finfo.Name)
+		for j := range finfo.Images {
+			img := &finfo.Images[j]
+			v, ok := views[key{name, img.hdu}]
+			if !ok || v.Scale <= 0 || v.QMin >= v.QMax {
+				continue
+			}
+			img.scale, img.orig, img.fit = v.Scale, image.Point{X: v.X, Y: v.Y}, v.Fit
+			img.qmin, img.qmax = v.QMin, v.QMax
+		}
+		if name == s.Current.File && s.Current.Image >= 0 && s.Current.Image < len(finfo.Images) {
+			c = cursor{file: i, img: s.Current.Image, plane: s.Current.Plane}
+			if c.plane < 0 || c.plane >= finfo.Images[c.img].planes {
+				c.plane = 0
+			}
+		}
+	}
+	return c
+}
+
+// regionList returns the regions of the session.
+func (s *session) regionList() ([]region, error) {
+	if s.Regions == "" {
+		return nil, nil
+	}
+	return parseRegions(strings.NewReader(s.Regions))
+}
+
+// loadSession reads the session file name.
+func loadSession(name string) (*session, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var s session
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// saveSession writes the session file name. The file is replaced once
+// the session is written, so that an error does not lose the previous
+// session.
+func saveSession(name string, s *session) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"image"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// sessionFiles returns files of two image HDUs, with the default view.
+func sessionFiles(names ...string) []fileInfo {
+	var infos []fileInfo
+	for _, name := range names {
+		finfo := fileInfo{Name: name}
+		for hdu := 0; hdu < 2; hdu++ {
+			finfo.Images = append(finfo.Images, imageInfo{
+				hduDesc: hduDesc{file: name, hdu: hdu, axes: []int{10, 10, 3}, planes: 3},
+				scale:   100,
+				fit:     true,
+				qmin:    0.01,
+				qmax:    0.99,
+			})
+		}
+		infos = append(infos, finfo)
+	}
+	return infos
+}
+
+func TestSession(t *testing.T) {
+	infos := sessionFiles("a.fits", "https://example.org/b.fits")
+	img := &infos[1].Images[1]
+	img.scale, img.orig, img.fit = 250, image.Point{X: 12, Y: -3}, false
+	img.qmin, img.qmax = 0.05, 0.95
+	regs := []region{{shape: "circle", coords: []float64{5, 6}, size: []float64{2}}}
+
+	s, err := newSession(infos, cursor{file: 1, img: 1, plane: 2}, "asinh", "viridis", regs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(t.TempDir(), "session.json")
+	if err := saveSession(name, s); err != nil {
+		t.Fatal(err)
+	}
+	got, err := loadSession(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, s) {
+		t.Fatalf("got=%+v, want=%+v", got, s)
+	}
+	if got.Stretch != "asinh" || got.Colormap != "viridis" || len(got.Files) != 2 {
+		t.Fatalf("invalid session: %+v", got)
+	}
+	gotRegs, err := got.regionList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(gotRegs, regs) {
+		t.Fatalf("got regions=%+v, want=%+v", gotRegs, regs)
+	}
+
+	// The file a.fits is missing from the restored files.
+	restoredInfos := sessionFiles("https://example.org/b.fits")
+	c := got.apply(restoredInfos)
+	if want := (cursor{file: 0, img: 1, plane: 2}); c != want {
+		t.Fatalf("got cursor=%+v, want=%+v", c, want)
+	}
+	if got, want := restoredInfos[0].Images[1], *img; got.scale != want.scale || got.orig != want.orig ||
+		got.fit != want.fit || got.qmin != want.qmin || got.qmax != want.qmax {
+		t.Fatalf("got view=%+v, want=%+v", got, want)
+	}
+	if got := restoredInfos[0].Images[0]; got.scale != 100 || !got.fit {
+		t.Fatalf("default view was changed: %+v", got)
+	}
+}
+
+func TestSessionExpr(t *testing.T) {
+	infos := sessionFiles(exprName, "a.fits")
+	infos[0].Images[0].expr = &imageExpr{}
+	s, err := newSession(infos, cursor{}, "linear", "gray", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{sessionPath("a.fits")}; !reflect.DeepEqual(s.Files, want) {
+		t.Fatalf("got files=%v, want=%v", s.Files, want)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"image"
+	"math"
+	"sort"
+
+	"gonum.org/v1/gonum/stat"
+)
+
+// imageStats holds the statistics of the pixels of an image or a region.
+type imageStats struct {
+	count, nans int // number of defined and blank pixels
+	min, max    float64
+	mean, std   float64
+	median, mad float64 // median and median absolute deviation
+
+	// Mean and standard deviation of the pixels within clipSigma
+	// standard deviations of the median, iterated until convergence.
+	clippedMean, clippedStd float64
+}
+
+const (
+	clipSigma = 3
+	clipIters = 10
+)
+
+// computeStats returns the statistics of the sorted defined pixel values,
+// nans being the number of blank pixels.
+func computeStats(sorted []float64, nans int) imageStats {
+	if len(sorted) == 0 {
+		nan := math.NaN()
+		return imageStats{nans: nans, min: nan, max: nan, mean: nan, std: nan,
+			median: nan, mad: nan, clippedMean: nan, clippedStd: nan}
+	}
+	s := imageStats{count: len(sorted), nans: nans}
+	s.min, s.max = sorted[0], sorted[len(sorted)-1]
+	s.mean, s.std = stat.MeanStdDev(sorted, nil)
+	s.median = stat.Quantile(0.5, stat.Empirical, sorted, nil)
+
+	dev := make([]float64, len(sorted))
+	for i, v := range sorted {
+		dev[i] = math.Abs(v - s.median)
+	}
+	sort.Float64s(dev)
+	s.mad = stat.Quantile(0.5, stat.Empirical, dev, nil)
+
+	s.clippedMean, s.clippedStd = sigmaClip(sorted, clipSigma, clipIters)
+	return s
+}
+
+// sigmaClip returns the mean and standard deviation of the sorted values
+// within nsigma standard deviations of their median, the values out of
+// this range being rejected until none is or iters is reached.
+func sigmaClip(sorted []float64, nsigma float64, iters int) (mean, std float64) {
+	kept := sorted
+	mean, std = stat.MeanStdDev(kept, nil)
+	for i := 0; i < iters && len(kept) > 1; i++ {
+		median := stat.Quantile(0.5, stat.Empirical, kept, nil)
+		lo := sort.SearchFloat64s(kept, median-nsigma*std)
+		hi := sort.Search(len(kept), func(i int) bool { return kept[i] > median+nsigma*std })
+		if lo == 0 && hi == len(kept) {
+			break
+		}
+		kept = kept[lo:hi]
+		mean, std = stat.MeanStdDev(kept, nil)
+	}
+	return mean, std
+}
+
+// regionPixels returns the sorted defined values of the pixels of img
+// whose centre is inside reg, and the number of blank pixels.
+func regionPixels(img *imageInfo, reg region) ([]float64, int) {
+	pts, ok := reg.outline(img.wcs)
+	if !ok || reg.shape == "point" {
+		return nil, 0
+	}
+	x0, y0, x1, y1 := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+	for _, p := range pts {
+		x0, y0 = math.Min(x0, p.x), math.Min(y0, p.y)
+		x1, y1 = math.Max(x1, p.x), math.Max(y1, p.y)
+	}
+	r := image.Rect(int(math.Floor(x0)), int(math.Floor(y0)), int(math.Ceil(x1)), int(math.Ceil(y1)))
+	r = r.Intersect(img.Bounds())
+
+	var values []float64
+	nans := 0
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if !reg.contains(img.wcs, fpoint{float64(x) + 0.5, float64(y) + 0.5}, 0) {
+				continue
+			}
+			v, _ := img.value(x, y)
+			if math.IsNaN(v) {
+				nans++
+			} else {
+				values = append(values, v)
+			}
+		}
+	}
+	sort.Float64s(values)
+	return values, nans
+}
+
+// histogram returns the number of sorted values in nbins bins between lo
+// and hi. Values out of the range are ignored.
+func histogram(sorted []float64, lo, hi float64, nbins int) []float64 {
+	counts := make([]float64, nbins)
+	if hi <= lo {
+		return counts
+	}
+	dividers := make([]float64, nbins+1)
+	for i := range dividers {
+		dividers[i] = lo + (hi-lo)*float64(i)/float64(nbins)
+	}
+	// The last bin includes hi.
+	dividers[nbins] = math.Nextafter(hi, math.Inf(1))
+	start := sort.SearchFloat64s(sorted, lo)
+	end := sort.SearchFloat64s(sorted, dividers[nbins])
+	if start >= end {
+		return counts
+	}
+	return stat.Histogram(counts, dividers, sorted[start:end], nil)
+}
+
+// quantileOf returns the fraction of the sorted values below v.
+func quantileOf(sorted []float64, v float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return float64(sort.SearchFloat64s(sorted, v)) / float64(len(sorted))
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestComputeStats(t *testing.T) {
+	// 1 to 9, and an outlier rejected by the sigma clipping.
+	sorted := []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 1000}
+	s := computeStats(sorted, 2)
+	if s.count != 10 || s.nans != 2 || s.min != 1 || s.max != 1000 {
+		t.Fatalf("invalid counts and range: %+v", s)
+	}
+	if s.mean != 104.5 || s.median != 5 || s.mad != 2 {
+		t.Fatalf("got mean=%v median=%v mad=%v, want 104.5, 5 and 2", s.mean, s.median, s.mad)
+	}
+	if s.clippedMean != 5 || math.Abs(s.clippedStd-math.Sqrt(7.5)) > 1e-12 {
+		t.Fatalf("got clipped mean=%v std=%v, want 5 and %v", s.clippedMean, s.clippedStd, math.Sqrt(7.5))
+	}
+
+	if s := computeStats(nil, 4); s.nans != 4 || !math.IsNaN(s.mean) {
+		t.Fatalf("invalid statistics without pixels: %+v", s)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	sorted := []float64{-1, 0, 0.5, 1, 1.5, 2, 3, 4, 4, 5}
+	got := histogram(sorted, 0, 4, 4)
+	if want := []float64{2, 2, 1, 3}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got=%v, want=%v", got, want)
+	}
+	if got := quantileOf(sorted, 1.5); got != 0.4 {
+		t.Fatalf("quantileOf(1.5)=%v, want 0.4", got)
+	}
+}
+
+func TestRegionPixels(t *testing.T) {
+	img := channel(0, 0, 0, 0, 0, 0)
+	img.axes = []int{3, 2}
+	img.Width, img.Height = 3, 2
+	img.Data = []float64{1, 2, 3, 4, math.NaN(), 6}
+
+	// Box over the two last columns, in FITS coordinates.
+	reg := region{shape: "box", coords: []float64{2.5, 1.5}, size: []float64{2, 2}}
+	values, nans := regionPixels(img, reg)
+	if want := []float64{2, 3, 6}; !reflect.DeepEqual(values, want) || nans != 1 {
+		t.Fatalf("got=(%v, %d), want=(%v, 1)", values, nans, want)
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+
+	"github.com/gotk3/gotk3/cairo"
+	"github.com/gotk3/gotk3/gdk"
+	"github.com/gotk3/gotk3/gtk"
+	"gonum.org/v1/gonum/stat"
+)
+
+// Number of bins of the histogram.
+const histBins = 128
+
+// statsRows returns the names and formatted values shown by the
+// statistics window.
+func statsRows(s imageStats) [][2]string {
+	return [][2]string{
+		{"Pixels", fmt.Sprint(s.count)},
+		{"NaN", fmt.Sprint(s.nans)},
+		{"Min", fmt.Sprintf("%g", s.min)},
+		{"Max", fmt.Sprintf("%g", s.max)},
+		{"Mean", fmt.Sprintf("%g", s.mean)},
+		{"Median", fmt.Sprintf("%g", s.median)},
+		{"Std", fmt.Sprintf("%g", s.std)},
+		{"MAD", fmt.Sprintf("%g", s.mad)},
+		{"Clipped mean", fmt.Sprintf("%g", s.clippedMean)},
+		{"Clipped std", fmt.Sprintf("%g", s.clippedStd)},
+	}
+}
+
+// statsView holds the widgets of the statistics window.
+type statsView struct {
+	*gtk.Window
+	title  *gtk.Label
+	values []*gtk.Label
+	hist   *gtk.DrawingArea
+	logY   *gtk.CheckButton
+
+	sorted     []float64 // pixel values, sorted
+	lo, hi     float64   // range of the histogram
+	vmin, vmax float64   // display limits
+
+	// Range selected by dragging on the histogram.
+	drag struct {
+		on     bool
+		x0, x1 float64
+	}
+
+	// onLimits is called with the new display limits when the user drags
+	// on the histogram.
+	onLimits func(vmin, vmax float64)
+}
+
+func statsWindow(parent *gtk.ApplicationWindow) *statsView {
+	win, err := gtk.WindowNew(gtk.WINDOW_TOPLEVEL)
+	if err != nil {
+		log.Fatal("Unable to create window:", err)
+	}
+	win.SetTitle("Statistics")
+	win.SetTransientFor(parent)
+	win.SetDefaultSize(400, 500)
+	win.HideOnDelete()
+
+	vbox, _ := gtk.BoxNew(gtk.ORIENTATION_VERTICAL, 0)
+	win.Add(vbox)
+
+	title, err := gtk.LabelNew("")
+	if err != nil {
+		log.Fatal("Unable to create label:", err)
+	}
+	vbox.PackStart(title, false, false, 5)
+
+	grid, err := gtk.GridNew()
+	if err != nil {
+		log.Fatal("Unable to create grid:", err)
+	}
+	grid.SetColumnSpacing(20)
+	grid.SetRowSpacing(2)
+	v := &statsView{Window: win, title: title}
+	for i, row := range statsRows(computeStats(nil, 0)) {
+		name, err := gtk.LabelNew(row[0])
+		if err != nil {
+			log.Fatal("Unable to create label:", err)
+		}
+		name.SetXAlign(0)
+		value, err := gtk.LabelNew("")
+		if err != nil {
+			log.Fatal("Unable to create label:", err)
+		}
+		value.SetXAlign(1)
+		value.SetSelectable(true)
+		grid.Attach(name, 0, i, 1, 1)
+		grid.Attach(value, 1, i, 1, 1)
+		v.values = append(v.values, value)
+	}
+	vbox.PackStart(grid, false, false, 10)
+
+	logY, err := gtk.CheckButtonNewWithLabel("Logarithmic counts")
+	if err != nil {
+		log.Fatal("Unable to create check button:", err)
+	}
+	vbox.PackStart(logY, false, false, 5)
+	v.logY = logY
+
+	hist, err := gtk.DrawingAreaNew()
+	if err != nil {
+		log.Fatal("Unable to create drawing area:", err)
+	}
+	hist.SetSizeRequest(300, 150)
+	hist.AddEvents(int(gdk.BUTTON_PRESS_MASK | gdk.BUTTON_RELEASE_MASK | gdk.POINTER_MOTION_MASK))
+	vbox.PackStart(hist, true, true, 5)
+	v.hist = hist
+
+	logY.Connect("toggled", hist.QueueDraw)
+	hist.Connect("draw", v.draw)
+
+	// Drag on the histogram to select the display limits.
+	hist.Connect("button-press-event", func(da *gtk.DrawingArea, ev *gdk.Event) bool {
+		btn := gdk.EventButtonNewFromEvent(ev)
+		if btn.Button() != gdk.BUTTON_PRIMARY || len(v.sorted) == 0 {
+			return false
+		}
+		v.drag.on = true
+		v.drag.x0, v.drag.x1 = btn.X(), btn.X()
+		return true
+	})
+	hist.Connect("motion-notify-event", func(da *gtk.DrawingArea, ev *gdk.Event) bool {
+		if !v.drag.on {
+			return false
+		}
+		v.drag.x1, _ = gdk.EventMotionNewFromEvent(ev).MotionVal()
+		da.QueueDraw()
+		return true
+	})
+	hist.Connect("button-release-event", func(da *gtk.DrawingArea, ev *gdk.Event) bool {
+		if !v.drag.on {
+			return false
+		}
+		v.drag.on = false
+		width := float64(da.GetAllocatedWidth())
+		vmin, vmax := v.valueAt(v.drag.x0, width), v.valueAt(v.drag.x1, width)
+		if vmin > vmax {
+			vmin, vmax = vmax, vmin
+		}
+		da.QueueDraw()
+		if vmin < vmax && v.onLimits != nil {
+			v.onLimits(vmin, vmax)
+		}
+		return true
+	})
+
+	vbox.ShowAll()
+	return v
+}
+
+// toggle shows or hides the statistics window.
+func (v *statsView) toggle() {
+	if v.GetVisible() {
+		v.Hide()
+	} else {
+		v.Present()
+	}
+}
+
+// update shows the statistics of the sorted pixel values, nans being the
+// number of blank pixels, and their histogram with the display limits.
+func (v *statsView) update(title string, sorted []float64, nans int, vmin, vmax float64) {
+	v.title.SetText(title)
+	for i, row := range statsRows(computeStats(sorted, nans)) {
+		v.values[i].SetText(row[1])
+	}
+
+	// Outliers are left out of the histogram, but not the display limits.
+	v.sorted, v.vmin, v.vmax = sorted, vmin, vmax
+	if len(sorted) > 0 {
+		v.lo = math.Min(stat.Quantile(0.001, stat.Empirical, sorted, nil), vmin)
+		v.hi = math.Max(stat.Quantile(0.999, stat.Empirical, sorted, nil), vmax)
+	}
+	v.hist.QueueDraw()
+}
+
+// valueAt returns the pixel value at the position x of the histogram.
+func (v *statsView) valueAt(x, width float64) float64 {
+	x = math.Min(math.Max(x, 0), width)
+	return v.lo + (v.hi-v.lo)*x/width
+}
+
+// posOf returns the position of the pixel value val on the histogram.
+func (v *statsView) posOf(val, width float64) float64 {
+	return (val - v.lo) / (v.hi - v.lo) * width
+}
+
+func (v *statsView) draw(da *gtk.DrawingArea, cr *cairo.Context) {
+	width, height := float64(da.GetAllocatedWidth()), float64(da.GetAllocatedHeight())
+	cr.SetSourceRGB(1, 1, 1)
+	cr.Paint()
+	if len(v.sorted) == 0 || v.hi <= v.lo {
+		return
+	}
+
+	counts := histogram(v.sorted, v.lo, v.hi, histBins)
+	logY := v.logY.GetActive()
+	var top float64
+	for i, c := range counts {
+		if logY {
+			counts[i] = math.Log10(1 + c)
+		}
+		top = math.Max(top, counts[i])
+	}
+	if top == 0 {
+		return
+	}
+
+	cr.SetSourceRGB(0.3, 0.3, 0.6)
+	bw := width / histBins
+	for i, c := range counts {
+		h := c / top * (height - 10)
+		cr.Rectangle(float64(i)*bw, height-h, bw, h)
+	}
+	cr.Fill()
+
+	// Display limits.
+	cr.SetSourceRGB(0.8, 0.2, 0.2)
+	cr.SetLineWidth(1)
+	for _, val := range []float64{v.vmin, v.vmax} {
+		x := v.posOf(val, width)
+		cr.MoveTo(x, 0)
+		cr.LineTo(x, height)
+	}
+	cr.Stroke()
+
+	if v.drag.on {
+		cr.SetSourceRGBA(1, 0.8, 0.2, 0.4)
+		cr.Rectangle(math.Min(v.drag.x0, v.drag.x1), 0, math.Abs(v.drag.x1-v.drag.x0), height)
+		cr.Fill()
+	}
+}
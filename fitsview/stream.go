@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// stdin holds the standard input, read once when the file "-" is first
+// opened, so that it can be read again and seeked.
+var stdin struct {
+	once sync.Once
+	data []byte
+	err  error
+}
+
+// openStdin returns a reader of the standard input.
+func openStdin() (io.ReadCloser, error) {
+	stdin.once.Do(func() {
+		stdin.data, stdin.err = ioutil.ReadAll(os.Stdin)
+	})
+	if stdin.err != nil {
+		return nil, fmt.Errorf("-: %v", stdin.err)
+	}
+	return ioutil.NopCloser(bytes.NewReader(stdin.data)), nil
+}
+
+// memFiles holds the files read from a stream, which can not be read
+// again, indexed by name. Only the last max files are kept, the data of
+// the older ones being dropped.
+type memFiles struct {
+	sync.Mutex
+	data    map[string][]byte
+	names   []string // in the order of arrival
+	max     int      // number of files kept, no limit if <= 0
+	dropped map[string]bool
+}
+
+// Frames read by the multi-frame mode, limited by -stream-keep.
+var frames = newMemFiles(0)
+
+func newMemFiles(max int) *memFiles {
+	return &memFiles{data: make(map[string][]byte), max: max, dropped: make(map[string]bool)}
+}
+
+func (m *memFiles) add(name string, data []byte) {
+	m.Lock()
+	defer m.Unlock()
+	m.data[name] = data
+	m.names = append(m.names, name)
+	if m.max > 0 && len(m.names) > m.max {
+		for _, old := range m.names[:len(m.names)-m.max] {
+			delete(m.data, old)
+			m.dropped[old] = true
+		}
+		m.names = append([]string(nil), m.names[len(m.names)-m.max:]...)
+	}
+}
+
+// open returns a reader of the file name, false if it was never held. It
+// returns an error for a file which was dropped.
+func (m *memFiles) open(name string) (io.ReadCloser, bool, error) {
+	m.Lock()
+	defer m.Unlock()
+	if m.dropped[name] {
+		return nil, true, fmt.Errorf("%s: frame dropped from memory, see -stream-keep", name)
+	}
+	data, ok := m.data[name]
+	if !ok {
+		return nil, false, nil
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), true, nil
+}
+
+// list returns the names of the files, in the order of arrival.
+func (m *memFiles) list() []string {
+	m.Lock()
+	defer m.Unlock()
+	return append([]string(nil), m.names...)
+}
+
+// readFrames splits the stream r into consecutive FITS files, and calls
+// frame with each one as soon as it is complete. A file is complete after
+// NEXTEND extensions, after its primary HDU when EXTEND is not true, or
+// else when the next file starts or the stream ends.
+func readFrames(r io.Reader, frame func([]byte)) error {
+	br := bufio.NewReaderSize(r, blockSize)
+	var buf bytes.Buffer
+	flush := func() {
+		if buf.Len() > 0 {
+			frame(append([]byte(nil), buf.Bytes()...))
+			buf.Reset()
+		}
+	}
+	// Number of extensions left in the current file, -1 if unknown.
+	left := -1
+	for n := 0; ; n++ {
+		start, err := br.Peek(8)
+		if err == io.EOF && len(start) == 0 {
+			flush()
+			return nil
+		}
+		if bytes.Equal(start, []byte("SIMPLE  ")) {
+			flush()
+		}
+		primary := buf.Len() == 0
+
+		cards, _, err := readHeader(io.TeeReader(br, &buf))
+		if err != nil {
+			return fmt.Errorf("frame HDU %d: %v", n, err)
+		}
+		if primary && cards["SIMPLE"] == "" {
+			return fmt.Errorf("frame HDU %d: missing primary header", n)
+		}
+		dsize := dataSize(cards)
+		if m, err := io.CopyN(&buf, br, dsize); err != nil {
+			if m < dsize && err == io.EOF {
+				err = fmt.Errorf("truncated data array")
+			}
+			return fmt.Errorf("frame HDU %d: %v", n, err)
+		}
+		// The padding of the last data array is sometimes missing.
+		pad := padBlock(dsize) - dsize
+		m, err := io.CopyN(&buf, br, pad)
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("frame HDU %d: %v", n, err)
+		}
+		buf.Write(make([]byte, pad-m))
+
+		switch {
+		case primary:
+			left = -1
+			if v, err := strconv.Atoi(cards["NEXTEND"]); err == nil && v >= 0 {
+				left = v
+			} else if cards["EXTEND"] != "T" {
+				left = 0
+			}
+		case left > 0:
+			left--
+		}
+		if left == 0 {
+			flush()
+		}
+	}
+}
+
+// streamFrames reads the consecutive FITS files of the stream name, "-"
+// for the standard input, and sends the names given to them as they
+// arrive. A named pipe is opened again when its writer closes it.
+func streamFrames(name string) (<-chan string, error) {
+	pipe := false
+	if name != "-" {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		pipe = fi.Mode()&os.ModeNamedPipe != 0
+	}
+	prefix := name
+	if name == "-" {
+		prefix = "stdin"
+	}
+
+	ch := make(chan string)
+	go func() {
+		defer close(ch)
+		n := 0
+		for {
+			var r io.ReadCloser = os.Stdin
+			if name != "-" {
+				// Opening a named pipe waits for a writer.
+				f, err := os.Open(name)
+				if err != nil {
+					log.Printf("could not open stream: %v\n", err)
+					return
+				}
+				r = f
+			}
+			err := readFrames(r, func(data []byte) {
+				n++
+				fname := fmt.Sprintf("%s#%d", prefix, n)
+				frames.add(fname, data)
+				ch <- fname
+			})
+			r.Close()
+			if err != nil {
+				log.Printf("could not read stream %s: %v\n", name, err)
+			}
+			if !pipe {
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
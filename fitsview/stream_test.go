@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+// fitsFile returns a FITS file made of the headers, each followed by a
+// block of data if it has an axis.
+func fitsFile(headers ...[]string) []byte {
+	var b bytes.Buffer
+	for _, cards := range headers {
+		b.Write(fitsHeader(cards...))
+		for _, c := range cards {
+			if c == "NAXIS   = 1" {
+				b.Write(make([]byte, blockSize))
+			}
+		}
+	}
+	return b.Bytes()
+}
+
+func TestReadFrames(t *testing.T) {
+	image := []string{"SIMPLE  = T", "BITPIX  = 8", "NAXIS   = 1", "NAXIS1  = 100"}
+	ext := []string{"XTENSION= 'IMAGE   '", "BITPIX  = 8", "NAXIS   = 1", "NAXIS1  = 100", "PCOUNT  = 0", "GCOUNT  = 1"}
+	files := [][]byte{
+		// Complete after the primary HDU.
+		fitsFile(image),
+		// Complete after NEXTEND extensions.
+		fitsFile([]string{"SIMPLE  = T", "BITPIX  = 8", "NAXIS   = 0", "EXTEND  = T", "NEXTEND = 2"}, ext, ext),
+		// Complete when the next file starts.
+		fitsFile([]string{"SIMPLE  = T", "BITPIX  = 8", "NAXIS   = 0", "EXTEND  = T"}, ext),
+		// Complete when the stream ends.
+		fitsFile(append(image, "EXTEND  = T"), ext),
+	}
+	var stream bytes.Buffer
+	for _, f := range files {
+		stream.Write(f)
+	}
+
+	// The frames are sent as soon as they are complete, the reader is at
+	// most one block ahead.
+	r := &countReader{r: &stream}
+	var got [][]byte
+	end := 0
+	err := readFrames(r, func(data []byte) {
+		end += len(data)
+		if r.n > end+blockSize {
+			t.Fatalf("frame %d: read %d bytes, want<=%d", len(got), r.n, end+blockSize)
+		}
+		got = append(got, data)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, files) {
+		t.Fatalf("got=%d frames, want=%d", len(got), len(files))
+	}
+
+	// The padding of the last data array may be missing.
+	data := fitsFile(image)
+	got = nil
+	if err := readFrames(bytes.NewReader(data[:len(data)-100]), func(data []byte) { got = append(got, data) }); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || !bytes.Equal(got[0], data) {
+		t.Fatalf("got=%d frames, want the padded file", len(got))
+	}
+
+	for _, data := range [][]byte{
+		fitsFile(ext),
+		data[:blockSize+50],
+		[]byte("not a FITS file"),
+	} {
+		if err := readFrames(bytes.NewReader(data), func([]byte) {}); err == nil {
+			t.Fatalf("expected an error")
+		}
+	}
+}
+
+// countReader counts the bytes read from r.
+type countReader struct {
+	r io.Reader
+	n int
+}
+
+func (r *countReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += n
+	return n, err
+}
+
+func TestMemFiles(t *testing.T) {
+	m := newMemFiles(2)
+	m.add("stdin#1", []byte("a"))
+	m.add("stdin#2", []byte("b"))
+	if got := m.list(); !reflect.DeepEqual(got, []string{"stdin#1", "stdin#2"}) {
+		t.Fatalf("got=%v", got)
+	}
+	r, ok, err := m.open("stdin#2")
+	if !ok || err != nil {
+		t.Fatalf("stdin#2 not found: %v", err)
+	}
+	if data, _ := ioutil.ReadAll(r); string(data) != "b" {
+		t.Fatalf("got=%q, want=%q", data, "b")
+	}
+	if _, ok, _ := m.open("stdin#3"); ok {
+		t.Fatalf("unexpected stdin#3")
+	}
+
+	// The oldest frame is dropped.
+	m.add("stdin#3", []byte("c"))
+	if got := m.list(); !reflect.DeepEqual(got, []string{"stdin#2", "stdin#3"}) {
+		t.Fatalf("got=%v", got)
+	}
+	if _, ok, err := m.open("stdin#1"); !ok || err == nil {
+		t.Fatalf("expected an error for the dropped stdin#1")
+	}
+	if len(m.data) != 2 {
+		t.Fatalf("got %d frames in memory, want 2", len(m.data))
+	}
+}
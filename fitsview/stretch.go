@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Stretch maps a pixel value, normalized to [0, 1] between the display
+// limits, to a display intensity in [0, 1].
+type Stretch interface {
+	Name() string
+	Apply(x float64) float64
+}
+
+// stretchNames lists the available stretches, in the order used when
+// cycling through them in the viewer.
+var stretchNames = []string{"linear", "log", "sqrt", "squared", "asinh", "histeq"}
+
+// newStretch returns the stretch called name. The histogram equalization
+// needs the sorted pixels of the image, the other stretches ignore them.
+func newStretch(name string, pixels []float64, vmin, vmax float64) (Stretch, error) {
+	switch name {
+	case "linear":
+		return linearStretch{}, nil
+	case "log":
+		return logStretch{a: 1000}, nil
+	case "sqrt":
+		return sqrtStretch{}, nil
+	case "squared":
+		return squaredStretch{}, nil
+	case "asinh":
+		return asinhStretch{beta: 0.1}, nil
+	case "histeq":
+		return newHistEqStretch(pixels, vmin, vmax), nil
+	}
+	return nil, fmt.Errorf("unknown stretch %q", name)
+}
+
+// isStretch reports whether name is a known stretch.
+func isStretch(name string) bool {
+	for _, n := range stretchNames {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// nextStretch returns the name of the stretch following name.
+func nextStretch(name string) string {
+	for i, n := range stretchNames {
+		if n == name {
+			return stretchNames[(i+1)%len(stretchNames)]
+		}
+	}
+	return stretchNames[0]
+}
+
+type linearStretch struct{}
+
+func (linearStretch) Name() string            { return "linear" }
+func (linearStretch) Apply(x float64) float64 { return x }
+
+// logStretch is the DS9 logarithmic stretch, log(a*x+1)/log(a+1).
+type logStretch struct {
+	a float64
+}
+
+func (logStretch) Name() string { return "log" }
+func (s logStretch) Apply(x float64) float64 {
+	return math.Log(s.a*x+1) / math.Log(s.a+1)
+}
+
+type sqrtStretch struct{}
+
+func (sqrtStretch) Name() string            { return "sqrt" }
+func (sqrtStretch) Apply(x float64) float64 { return math.Sqrt(x) }
+
+type squaredStretch struct{}
+
+func (squaredStretch) Name() string            { return "squared" }
+func (squaredStretch) Apply(x float64) float64 { return x * x }
+
+// asinhStretch is the Lupton et al. (1999) asinh stretch, linear for
+// x << beta and logarithmic for x >> beta.
+type asinhStretch struct {
+	beta float64
+}
+
+func (asinhStretch) Name() string { return "asinh" }
+func (s asinhStretch) Apply(x float64) float64 {
+	return math.Asinh(x/s.beta) / math.Asinh(1/s.beta)
+}
+
+// histEqStretch maps values through the cumulative distribution of the
+// image pixels, so that every display level is equally populated.
+type histEqStretch struct {
+	cdf []float64
+}
+
+const histEqBins = 1024
+
+// newHistEqStretch computes the cumulative distribution of the sorted
+// pixels between vmin and vmax.
+func newHistEqStretch(pixels []float64, vmin, vmax float64) histEqStretch {
+	lo := sort.SearchFloat64s(pixels, vmin)
+	hi := sort.Search(len(pixels), func(i int) bool { return pixels[i] > vmax })
+
+	cdf := make([]float64, histEqBins+1)
+	for i := range cdf {
+		if hi <= lo {
+			cdf[i] = float64(i) / histEqBins
+			continue
+		}
+		v := vmin + float64(i)/histEqBins*(vmax-vmin)
+		n := sort.Search(len(pixels), func(j int) bool { return pixels[j] > v })
+		cdf[i] = float64(n-lo) / float64(hi-lo)
+	}
+	return histEqStretch{cdf: cdf}
+}
+
+func (histEqStretch) Name() string { return "histeq" }
+func (s histEqStretch) Apply(x float64) float64 {
+	return s.cdf[int(x*histEqBins+0.5)]
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestStretches(t *testing.T) {
+	for _, s := range []Stretch{
+		linearStretch{},
+		logStretch{a: 1000},
+		sqrtStretch{},
+		squaredStretch{},
+		asinhStretch{beta: 0.1},
+		newHistEqStretch([]float64{0, 0.25, 0.5, 0.75, 1}, 0, 1),
+	} {
+		name := s.Name()
+		if !isStretch(name) {
+			t.Fatalf("%s: not a registered stretch", name)
+		}
+		if v := s.Apply(1); math.Abs(v-1) > 1e-12 {
+			t.Fatalf("%s: Apply(1)=%v, want 1", name, v)
+		}
+		prev := s.Apply(0)
+		for i := 1; i <= 100; i++ {
+			v := s.Apply(float64(i) / 100)
+			if v < prev {
+				t.Fatalf("%s: not monotonic at %v", name, float64(i)/100)
+			}
+			prev = v
+		}
+	}
+}
+
+func TestHistEqStretch(t *testing.T) {
+	s := newHistEqStretch([]float64{10, 10, 10, 20, 30, 40, 100}, 10, 40)
+	for _, table := range []struct {
+		x, want float64
+	}{
+		{x: 0, want: 0.5},
+		{x: 0.5, want: 4.0 / 6},
+		{x: 1, want: 1},
+	} {
+		if got := s.Apply(table.x); math.Abs(got-table.want) > 1e-2 {
+			t.Fatalf("Apply(%v)=%v, want %v", table.x, got, table.want)
+		}
+	}
+}
+
+func TestNextStretch(t *testing.T) {
+	name := "linear"
+	for range stretchNames {
+		name = nextStretch(name)
+	}
+	if name != "linear" {
+		t.Fatalf("cycling did not come back to linear: %q", name)
+	}
+	if _, err := newStretch("foo", nil, 0, 1); err == nil {
+		t.Fatalf("expected an error for an unknown stretch")
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/saimn/fitsio"
+)
+
+// Number of rows on a page of the table browser.
+const tablePageSize = 100
+
+// Number of elements of a vector shown in a cell.
+const maxVectorCells = 8
+
+// tableColumn describes a column of a table.
+type tableColumn struct {
+	name   string
+	unit   string // TUNITn
+	format string // TFORMn
+}
+
+// title returns the title of the column in the table browser.
+func (c tableColumn) title() string {
+	t := c.name
+	if c.unit != "" {
+		t += " [" + c.unit + "]"
+	}
+	return t + "\n" + c.format
+}
+
+// tableData holds the rows of a table HDU. Vector columns have a slice
+// per cell.
+type tableData struct {
+	cols []tableColumn
+	rows [][]interface{}
+}
+
+// readTable reads the table HDU described by desc.
+func readTable(desc hduDesc) (*tableData, error) {
+	f, hdu, err := openHDU(desc)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	tbl, ok := hdu.(*fitsio.Table)
+	if !ok {
+		return nil, fmt.Errorf("%s[%d]: not a table", desc.file, desc.hdu)
+	}
+	t, err := newTableData(tbl)
+	if err != nil {
+		return nil, fmt.Errorf("%s[%d]: %v", desc.file, desc.hdu, err)
+	}
+	return t, nil
+}
+
+// newTableData reads the columns and the rows of tbl.
+func newTableData(tbl *fitsio.Table) (*tableData, error) {
+	t := &tableData{}
+	for _, c := range tbl.Cols() {
+		t.cols = append(t.cols, tableColumn{name: c.Name, unit: c.Unit, format: c.Format})
+	}
+
+	rows, err := tbl.Read(0, tbl.NumRows())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		cols := make(map[string]interface{})
+		if err := rows.Scan(&cols); err != nil {
+			return nil, err
+		}
+		row := make([]interface{}, len(t.cols))
+		for i, c := range t.cols {
+			row[i] = cols[c.name]
+		}
+		t.rows = append(t.rows, row)
+	}
+	return t, rows.Err()
+}
+
+// formatCell formats the value of a cell. Only the first elements of
+// long vectors are shown, followed by their number of elements.
+func formatCell(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return strings.TrimRight(v, " ")
+	case float32:
+		return fmt.Sprint(v)
+	case float64:
+		return fmt.Sprint(v)
+	}
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() || (rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array) {
+		return cardString(v)
+	}
+	n := rv.Len()
+	var elems []string
+	for i := 0; i < n && i < maxVectorCells; i++ {
+		elems = append(elems, formatCell(rv.Index(i).Interface()))
+	}
+	s := "[" + strings.Join(elems, " ")
+	if n > maxVectorCells {
+		s += fmt.Sprintf(" … (%d)", n)
+	}
+	return s + "]"
+}
+
+// cellFloat returns the value of a numeric scalar cell.
+func cellFloat(v interface{}) (float64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+	return 0, false
+}
+
+// numericColumns returns the indices of the columns holding numeric
+// scalars, which can be plotted.
+func (t *tableData) numericColumns() []int {
+	var cols []int
+	for i := range t.cols {
+		if len(t.rows) == 0 {
+			break
+		}
+		if _, ok := cellFloat(t.rows[0][i]); ok {
+			cols = append(cols, i)
+		}
+	}
+	return cols
+}
+
+// columnValues returns the values of the numeric column col, NaN for the
+// cells which are not numbers.
+func (t *tableData) columnValues(col int) []float64 {
+	values := make([]float64, len(t.rows))
+	for i, row := range t.rows {
+		v, ok := cellFloat(row[col])
+		if !ok {
+			v = math.NaN()
+		}
+		values[i] = v
+	}
+	return values
+}
+
+// sortRows returns the indices of the rows sorted by the column col.
+// Numbers are sorted by value with NaN last, other cells by their text.
+func (t *tableData) sortRows(col int, descending bool) []int {
+	perm := make([]int, len(t.rows))
+	for i := range perm {
+		perm[i] = i
+	}
+	if col < 0 || col >= len(t.cols) {
+		return perm
+	}
+
+	less := func(i, j int) bool {
+		a, b := formatCell(t.rows[i][col]), formatCell(t.rows[j][col])
+		if descending {
+			return a > b
+		}
+		return a < b
+	}
+	if len(t.rows) > 0 {
+		if _, ok := cellFloat(t.rows[0][col]); ok {
+			values := t.columnValues(col)
+			less = func(i, j int) bool {
+				a, b := values[i], values[j]
+				switch {
+				case math.IsNaN(a):
+					return false
+				case math.IsNaN(b):
+					return true
+				case descending:
+					return a > b
+				}
+				return a < b
+			}
+		}
+	}
+	sort.SliceStable(perm, func(a, b int) bool { return less(perm[a], perm[b]) })
+	return perm
+}
+
+// pageRows returns the rows of the page p, as indices in perm.
+func pageRows(perm []int, p int) []int {
+	start := p * tablePageSize
+	if start >= len(perm) {
+		return nil
+	}
+	end := start + tablePageSize
+	if end > len(perm) {
+		end = len(perm)
+	}
+	return perm[start:end]
+}
+
+// pageCount returns the number of pages of n rows, at least one.
+func pageCount(n int) int {
+	if n == 0 {
+		return 1
+	}
+	return (n + tablePageSize - 1) / tablePageSize
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestFormatCell(t *testing.T) {
+	for _, table := range []struct {
+		v    interface{}
+		want string
+	}{
+		{int16(-3), "-3"},
+		{float32(1.5), "1.5"},
+		{2.25, "2.25"},
+		{"NGC 1316  ", "NGC 1316"},
+		{true, "T"},
+		{nil, ""},
+		{[]float64{1, 2.5}, "[1 2.5]"},
+		{[]int32{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, "[1 2 3 4 5 6 7 8 … (10)]"},
+		{[3]uint8{1, 2, 3}, "[1 2 3]"},
+	} {
+		if got := formatCell(table.v); got != table.want {
+			t.Fatalf("formatCell(%#v): got=%q, want=%q", table.v, got, table.want)
+		}
+	}
+}
+
+func TestSortRows(t *testing.T) {
+	data := &tableData{
+		cols: []tableColumn{{name: "NAME"}, {name: "MAG", unit: "mag", format: "E"}, {name: "FLUX", format: "2D"}},
+		rows: [][]interface{}{
+			{"b", float32(12), []float64{1, 2}},
+			{"c", float32(math.NaN()), []float64{3, 4}},
+			{"a", float32(10), []float64{5, 6}},
+			{"d", float32(11), []float64{7, 8}},
+		},
+	}
+	if got := data.cols[1].title(); got != "MAG [mag]\nE" {
+		t.Fatalf("invalid title: %q", got)
+	}
+	if got, want := data.numericColumns(), []int{1}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("numeric columns: got=%v, want=%v", got, want)
+	}
+
+	for _, table := range []struct {
+		col        int
+		descending bool
+		want       []int
+	}{
+		{-1, false, []int{0, 1, 2, 3}},
+		{0, false, []int{2, 0, 1, 3}},
+		{0, true, []int{3, 1, 0, 2}},
+		{1, false, []int{2, 3, 0, 1}},
+		{1, true, []int{0, 3, 2, 1}},
+	} {
+		if got := data.sortRows(table.col, table.descending); !reflect.DeepEqual(got, table.want) {
+			t.Fatalf("sortRows(%d, %v): got=%v, want=%v", table.col, table.descending, got, table.want)
+		}
+	}
+}
+
+func TestPageRows(t *testing.T) {
+	perm := make([]int, 2*tablePageSize+5)
+	for i := range perm {
+		perm[i] = i
+	}
+	if n := pageCount(len(perm)); n != 3 {
+		t.Fatalf("got %d pages, want 3", n)
+	}
+	if n := pageCount(0); n != 1 {
+		t.Fatalf("got %d pages without rows, want 1", n)
+	}
+	rows := pageRows(perm, 2)
+	if len(rows) != 5 || rows[0] != 2*tablePageSize {
+		t.Fatalf("invalid last page: %v", rows)
+	}
+	if rows := pageRows(perm, 3); rows != nil {
+		t.Fatalf("got rows after the last page: %v", rows)
+	}
+}
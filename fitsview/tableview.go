@@ -0,0 +1,318 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/gotk3/gotk3/glib"
+	"github.com/gotk3/gotk3/gtk"
+)
+
+// tableView holds the widgets of the table browser.
+type tableView struct {
+	*gtk.Window
+	fileCombo      *gtk.ComboBoxText
+	hduCombo       *gtk.ComboBoxText
+	xCombo, yCombo *gtk.ComboBoxText
+	scroll         *gtk.ScrolledWindow
+	tree           *gtk.TreeView
+	store          *gtk.ListStore
+	columns        []*gtk.TreeViewColumn
+	pageLab        *gtk.Label
+	plot           *plotView
+
+	files    []fileInfo // files with tables, in the file combo
+	file     string     // file whose tables are shown
+	tables   []hduDesc  // tables of the file
+	data     *tableData
+	numeric  []int // numeric columns, in the scatter plot combos
+	perm     []int // rows in the sorted order
+	page     int
+	sortCol  int // sorted column, -1 if none
+	sortDesc bool
+	updating bool // set while the combos are filled
+}
+
+func tableWindow(parent *gtk.ApplicationWindow, plot *plotView) *tableView {
+	win, err := gtk.WindowNew(gtk.WINDOW_TOPLEVEL)
+	if err != nil {
+		log.Fatal("Unable to create window:", err)
+	}
+	win.SetTransientFor(parent)
+	win.HideOnDelete()
+	return newTableView(win, plot)
+}
+
+// newTableView creates the table browser in win, the main window when no
+// file has an image.
+func newTableView(win *gtk.Window, plot *plotView) *tableView {
+	var err error
+	win.SetTitle("Tables")
+	win.SetDefaultSize(800, 500)
+
+	vbox, _ := gtk.BoxNew(gtk.ORIENTATION_VERTICAL, 0)
+	win.Add(vbox)
+	v := &tableView{Window: win, plot: plot, sortCol: -1}
+
+	// File and HDU selection, and pages.
+	hbox, _ := gtk.BoxNew(gtk.ORIENTATION_HORIZONTAL, 0)
+	vbox.PackStart(hbox, false, false, 5)
+	v.fileCombo = comboBox()
+	hbox.PackStart(v.fileCombo, false, false, 5)
+	v.hduCombo = comboBox()
+	hbox.PackStart(v.hduCombo, false, false, 5)
+	prev := button("Previous")
+	hbox.PackStart(prev, false, false, 5)
+	v.pageLab, err = gtk.LabelNew("")
+	if err != nil {
+		log.Fatal("Unable to create label:", err)
+	}
+	hbox.PackStart(v.pageLab, false, false, 5)
+	next := button("Next")
+	hbox.PackStart(next, false, false, 5)
+
+	// Scatter plot of two columns.
+	scatter := button("Scatter plot")
+	hbox.PackEnd(scatter, false, false, 5)
+	v.yCombo = comboBox()
+	hbox.PackEnd(v.yCombo, false, false, 5)
+	v.xCombo = comboBox()
+	hbox.PackEnd(v.xCombo, false, false, 5)
+
+	v.scroll, err = gtk.ScrolledWindowNew(nil, nil)
+	if err != nil {
+		log.Fatal("Unable to create scrolled window:", err)
+	}
+	vbox.PackStart(v.scroll, true, true, 0)
+
+	v.fileCombo.Connect("changed", func() {
+		if i := v.fileCombo.GetActive(); !v.updating && i >= 0 && i < len(v.files) {
+			v.setFile(v.files[i])
+		}
+	})
+	v.hduCombo.Connect("changed", func() {
+		if !v.updating {
+			v.load(v.hduCombo.GetActive())
+		}
+	})
+	prev.Connect("clicked", func() { v.showPage(v.page - 1) })
+	next.Connect("clicked", func() { v.showPage(v.page + 1) })
+	scatter.Connect("clicked", v.scatter)
+
+	vbox.ShowAll()
+	return v
+}
+
+func comboBox() *gtk.ComboBoxText {
+	combo, err := gtk.ComboBoxTextNew()
+	if err != nil {
+		log.Fatal("Unable to create combo box:", err)
+	}
+	return combo
+}
+
+func button(label string) *gtk.Button {
+	btn, err := gtk.ButtonNewWithLabel(label)
+	if err != nil {
+		log.Fatal("Unable to create button:", err)
+	}
+	return btn
+}
+
+// setFiles lists the files of infos which have tables in the file combo.
+func (v *tableView) setFiles(infos []fileInfo) {
+	v.files = nil
+	v.updating = true
+	v.fileCombo.RemoveAll()
+	for _, finfo := range infos {
+		if len(finfo.Tables) > 0 {
+			v.files = append(v.files, finfo)
+			v.fileCombo.AppendText(finfo.Name)
+		}
+	}
+	v.updating = false
+	v.selectFile()
+}
+
+// selectFile selects the file shown in the file combo, if listed.
+func (v *tableView) selectFile() {
+	active := -1
+	for i, finfo := range v.files {
+		if finfo.Name == v.file {
+			active = i
+		}
+	}
+	v.updating = true
+	v.fileCombo.SetActive(active)
+	v.updating = false
+}
+
+// setFile shows the tables of finfo, unless they are already shown.
+func (v *tableView) setFile(finfo fileInfo) {
+	if finfo.Name == v.file {
+		return
+	}
+	v.file, v.tables = finfo.Name, finfo.Tables
+	v.SetTitle("Tables of " + finfo.Name)
+	v.selectFile()
+
+	v.updating = true
+	v.hduCombo.RemoveAll()
+	for _, desc := range v.tables {
+		v.hduCombo.AppendText(fmt.Sprintf("HDU %d (%d rows)", desc.hdu, desc.axes[1]))
+	}
+	v.updating = false
+
+	if len(v.tables) == 0 {
+		v.setData(nil)
+		v.pageLab.SetText("no table in this file")
+		return
+	}
+	v.updating = true
+	v.hduCombo.SetActive(0)
+	v.updating = false
+	v.load(0)
+}
+
+// load reads and shows the table i of the file.
+func (v *tableView) load(i int) {
+	if i < 0 || i >= len(v.tables) {
+		return
+	}
+	data, err := readTable(v.tables[i])
+	if err != nil {
+		log.Printf("could not read table: %v\n", err)
+		v.setData(nil)
+		v.pageLab.SetText("could not read the table")
+		return
+	}
+	v.setData(data)
+}
+
+// setData replaces the table view by a view of the columns of data.
+func (v *tableView) setData(data *tableData) {
+	v.data, v.page, v.sortCol, v.sortDesc = data, 0, -1, false
+	if v.tree != nil {
+		v.scroll.Remove(v.tree)
+		v.tree, v.store, v.columns = nil, nil, nil
+	}
+	v.updating = true
+	v.xCombo.RemoveAll()
+	v.yCombo.RemoveAll()
+	v.updating = false
+	if data == nil {
+		return
+	}
+
+	types := make([]glib.Type, len(data.cols))
+	for i := range types {
+		types[i] = glib.TYPE_STRING
+	}
+	store, err := gtk.ListStoreNew(types...)
+	if err != nil {
+		log.Fatal("Unable to create list store:", err)
+	}
+	tree, err := gtk.TreeViewNewWithModel(store)
+	if err != nil {
+		log.Fatal("Unable to create tree view:", err)
+	}
+	for i, c := range data.cols {
+		renderer, err := gtk.CellRendererTextNew()
+		if err != nil {
+			log.Fatal("Unable to create cell renderer:", err)
+		}
+		column, err := gtk.TreeViewColumnNewWithAttribute(c.title(), renderer, "text", i)
+		if err != nil {
+			log.Fatal("Unable to create tree view column:", err)
+		}
+		column.SetResizable(true)
+		column.SetClickable(true)
+		col := i
+		column.Connect("clicked", func() { v.sortBy(col) })
+		tree.AppendColumn(column)
+		v.columns = append(v.columns, column)
+	}
+	v.scroll.Add(tree)
+	tree.ShowAll()
+	v.tree, v.store = tree, store
+
+	v.numeric = data.numericColumns()
+	v.updating = true
+	for _, i := range v.numeric {
+		v.xCombo.AppendText(data.cols[i].name)
+		v.yCombo.AppendText(data.cols[i].name)
+	}
+	if len(v.numeric) >= 2 {
+		v.xCombo.SetActive(0)
+		v.yCombo.SetActive(1)
+	}
+	v.updating = false
+
+	v.perm = data.sortRows(-1, false)
+	v.showPage(0)
+}
+
+// sortBy sorts the rows by the column col, in the reverse order if they
+// are already sorted by this column.
+func (v *tableView) sortBy(col int) {
+	if v.sortCol == col {
+		v.sortDesc = !v.sortDesc
+	} else {
+		v.sortCol, v.sortDesc = col, false
+	}
+	for i, column := range v.columns {
+		column.SetSortIndicator(i == col)
+	}
+	if v.sortDesc {
+		v.columns[col].SetSortOrder(gtk.SORT_DESCENDING)
+	} else {
+		v.columns[col].SetSortOrder(gtk.SORT_ASCENDING)
+	}
+	v.perm = v.data.sortRows(col, v.sortDesc)
+	v.showPage(0)
+}
+
+// showPage shows the rows of the page p.
+func (v *tableView) showPage(p int) {
+	if v.data == nil {
+		return
+	}
+	n := pageCount(len(v.perm))
+	if p < 0 || p >= n {
+		return
+	}
+	v.page = p
+	v.store.Clear()
+	cols := make([]int, len(v.data.cols))
+	values := make([]interface{}, len(v.data.cols))
+	for i := range cols {
+		cols[i] = i
+	}
+	for _, r := range pageRows(v.perm, p) {
+		for i, cell := range v.data.rows[r] {
+			values[i] = formatCell(cell)
+		}
+		if err := v.store.Set(v.store.Append(), cols, values); err != nil {
+			log.Printf("could not show row %d: %v\n", r+1, err)
+		}
+	}
+	v.pageLab.SetText(fmt.Sprintf("page %d/%d, %d rows", p+1, n, len(v.perm)))
+}
+
+// scatter plots the two numeric columns selected in the combos.
+func (v *tableView) scatter() {
+	xi, yi := v.xCombo.GetActive(), v.yCombo.GetActive()
+	if v.data == nil || xi < 0 || yi < 0 || xi >= len(v.numeric) || yi >= len(v.numeric) {
+		return
+	}
+	x, y := v.data.cols[v.numeric[xi]], v.data.cols[v.numeric[yi]]
+	label := func(c tableColumn) string {
+		if c.unit != "" {
+			return c.name + " (" + c.unit + ")"
+		}
+		return c.name
+	}
+	v.plot.plot(fmt.Sprintf("%s vs %s", y.name, x.name), label(x), label(y),
+		v.data.columnValues(v.numeric[xi]), v.data.columnValues(v.numeric[yi]), true,
+		fmt.Sprintf("%s, %d rows", v.file, len(v.data.rows)))
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"log"
+	"math"
+	"runtime"
+	"sort"
+	"sync"
+
+	"gonum.org/v1/gonum/stat"
+)
+
+// Space between the thumbnails of the grid and of the contact sheet, in
+// pixels.
+const thumbMargin = 6
+
+// Background of the contact sheet, where blank pixels are transparent.
+var sheetBackground = color.RGBA{40, 40, 40, 255}
+
+// thumbJob describes a thumbnail to compute.
+type thumbJob struct {
+	desc       hduDesc
+	qmin, qmax float64 // quantiles of the display limits
+}
+
+// thumbOptions holds the rendering options of the thumbnails.
+type thumbOptions struct {
+	size    int // size of the square holding a thumbnail
+	stretch string
+	cmap    *Colormap
+}
+
+// makeThumbnail decodes the image of job and renders its thumbnail. The
+// image cache is not used, so that the images shown are not evicted.
+func makeThumbnail(job thumbJob, opts thumbOptions) (*image.RGBA, error) {
+	d, err := decodeHDU(job.desc)
+	if err != nil {
+		return nil, err
+	}
+	return renderThumbnail(d.floatImage, job.qmin, job.qmax, opts)
+}
+
+// renderThumbnail returns the image f scaled down to fit in a square of
+// opts.size pixels and stretched between the quantiles qmin and qmax of
+// its pixels.
+func renderThumbnail(f *floatImage, qmin, qmax float64, opts thumbOptions) (*image.RGBA, error) {
+	small := shrinkImage(f, opts.size)
+	pixels, _ := getPixels(small)
+	if len(pixels) == 0 {
+		return image.NewRGBA(small.Bounds()), nil
+	}
+	sort.Float64s(pixels)
+	vmin := stat.Quantile(qmin, stat.Empirical, pixels, nil)
+	vmax := stat.Quantile(qmax, stat.Empirical, pixels, nil)
+	stretch, err := newStretch(opts.stretch, pixels, vmin, vmax)
+	if err != nil {
+		return nil, err
+	}
+	return stretchImage(small, vmin, vmax, stretch, opts.cmap), nil
+}
+
+// shrinkImage returns f scaled down to fit in a square of size pixels,
+// each pixel being the mean of the pixels it covers, ignoring NaN. Images
+// smaller than size are returned as is.
+func shrinkImage(f *floatImage, size int) *floatImage {
+	n := f.Width
+	if f.Height > n {
+		n = f.Height
+	}
+	if n <= size {
+		return f
+	}
+	width := int(math.Max(1, math.Round(float64(f.Width*size)/float64(n))))
+	height := int(math.Max(1, math.Round(float64(f.Height*size)/float64(n))))
+	small := &floatImage{Data: make([]float64, width*height), Width: width, Height: height}
+	for y := 0; y < height; y++ {
+		y0, y1 := y*f.Height/height, (y+1)*f.Height/height
+		for x := 0; x < width; x++ {
+			x0, x1 := x*f.Width/width, (x+1)*f.Width/width
+			sum, count := 0.0, 0
+			for j := y0; j < y1; j++ {
+				for _, v := range f.Data[j*f.Width+x0 : j*f.Width+x1] {
+					if !math.IsNaN(v) {
+						sum += v
+						count++
+					}
+				}
+			}
+			v := math.NaN()
+			if count > 0 {
+				v = sum / float64(count)
+			}
+			small.Data[y*width+x] = v
+		}
+	}
+	return small
+}
+
+// runPool calls work for each index in [0, n) from workers goroutines,
+// and returns once all the calls returned. The indices not started yet
+// are skipped once stop is closed.
+func runPool(n, workers int, stop <-chan struct{}, work func(i int)) {
+	if workers < 1 {
+		workers = 1
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				work(i)
+			}
+		}()
+	}
+	defer wg.Wait()
+	defer close(jobs)
+	for i := 0; i < n; i++ {
+		select {
+		case jobs <- i:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// sheetColumns returns the number of columns of a contact sheet of n
+// thumbnails, close to a square.
+func sheetColumns(n int) int {
+	if n <= 1 {
+		return 1
+	}
+	return int(math.Ceil(math.Sqrt(float64(n))))
+}
+
+// cellOrigin returns the upper left corner of the cell i of a grid of
+// cols columns, for thumbnails of size pixels.
+func cellOrigin(i, cols, size int) image.Point {
+	step := size + thumbMargin
+	return image.Pt(thumbMargin+i%cols*step, thumbMargin+i/cols*step)
+}
+
+// cellAt returns the cell of a grid of cols columns and n cells at the
+// position p, -1 if none.
+func cellAt(p image.Point, cols, n, size int) int {
+	step := size + thumbMargin
+	if p.X < thumbMargin || p.Y < thumbMargin {
+		return -1
+	}
+	x, y := (p.X-thumbMargin)/step, (p.Y-thumbMargin)/step
+	if x >= cols || (p.X-thumbMargin)%step >= size || (p.Y-thumbMargin)%step >= size {
+		return -1
+	}
+	if i := y*cols + x; i < n {
+		return i
+	}
+	return -1
+}
+
+// contactSheet draws the thumbs on a grid of cols columns, each one
+// centred in a square of size pixels. Missing thumbnails are left blank.
+func contactSheet(thumbs []*image.RGBA, cols, size int) *image.RGBA {
+	rows := (len(thumbs) + cols - 1) / cols
+	if rows == 0 {
+		rows = 1
+	}
+	end := cellOrigin(rows*cols-1, cols, size).Add(image.Pt(size+thumbMargin, size+thumbMargin))
+	sheet := image.NewRGBA(image.Rectangle{Max: end})
+	draw.Draw(sheet, sheet.Bounds(), &image.Uniform{sheetBackground}, image.Point{}, draw.Src)
+	for i, m := range thumbs {
+		if m == nil {
+			continue
+		}
+		b := m.Bounds()
+		o := cellOrigin(i, cols, size).Add(image.Pt((size-b.Dx())/2, (size-b.Dy())/2))
+		draw.Draw(sheet, b.Sub(b.Min).Add(o), m, b.Min, draw.Over)
+	}
+	return sheet
+}
+
+// exportContactSheet writes a PNG contact sheet of the thumbnails of all
+// the images of infos to name, without starting the GTK application.
+func exportContactSheet(infos []fileInfo, name string, size int) error {
+	cmap, err := lookupColormap(*cmapName)
+	if err != nil {
+		return err
+	}
+	opts := thumbOptions{size: size, stretch: *stretchName, cmap: cmap}
+	var jobs []thumbJob
+	var labels []string
+	for _, finfo := range infos {
+		for _, img := range finfo.Images {
+			jobs = append(jobs, thumbJob{img.hduDesc, img.qmin, img.qmax})
+			labels = append(labels, fmt.Sprintf("%s[%d]", finfo.Name, img.hdu))
+		}
+	}
+
+	thumbs := make([]*image.RGBA, len(jobs))
+	runPool(len(jobs), runtime.NumCPU(), nil, func(i int) {
+		m, err := makeThumbnail(jobs[i], opts)
+		if err != nil {
+			log.Printf("could not make the thumbnail of %s: %v\n", labels[i], err)
+			return
+		}
+		thumbs[i] = m
+	})
+
+	cols := sheetColumns(len(thumbs))
+	if err := savePNG(name, contactSheet(thumbs, cols, size)); err != nil {
+		return err
+	}
+	for i, label := range labels {
+		log.Printf("row %d column %d: %s\n", i/cols+1, i%cols+1, label)
+	}
+	log.Printf("exported %s\n", name)
+	return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"image"
+	"math"
+	"sync"
+	"testing"
+)
+
+func TestShrinkImage(t *testing.T) {
+	f := &floatImage{Data: make([]float64, 8*4), Width: 8, Height: 4}
+	for i := range f.Data {
+		f.Data[i] = float64(i % 8)
+	}
+	f.Data[0], f.Data[8] = math.NaN(), math.NaN()
+
+	small := shrinkImage(f, 4)
+	if small.Width != 4 || small.Height != 2 {
+		t.Fatalf("got=%dx%d, want=4x2", small.Width, small.Height)
+	}
+	// Each pixel is the mean of 2x2 pixels, ignoring NaN.
+	want := []float64{1, 2.5, 4.5, 6.5, 0.5, 2.5, 4.5, 6.5}
+	for i, v := range want {
+		if small.Data[i] != v {
+			t.Fatalf("pixel %d: got=%v, want=%v", i, small.Data[i], v)
+		}
+	}
+	if got := shrinkImage(f, 10); got != f {
+		t.Fatalf("small images are not shrunk")
+	}
+
+	cmap, _ := lookupColormap("gray")
+	m, err := renderThumbnail(f, 0, 1, thumbOptions{size: 4, stretch: "linear", cmap: cmap})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Bounds() != image.Rect(0, 0, 4, 2) {
+		t.Fatalf("got=%v, want 4x2", m.Bounds())
+	}
+}
+
+func TestRunPool(t *testing.T) {
+	var mu sync.Mutex
+	done := make(map[int]int)
+	runPool(50, 4, nil, func(i int) {
+		mu.Lock()
+		done[i]++
+		mu.Unlock()
+	})
+	for i := 0; i < 50; i++ {
+		if done[i] != 1 {
+			t.Fatalf("job %d: got=%d calls, want=1", i, done[i])
+		}
+	}
+
+	stop := make(chan struct{})
+	n := 0
+	runPool(50, 1, stop, func(i int) {
+		if n++; n == 3 {
+			close(stop)
+		}
+	})
+	if n > 4 {
+		t.Fatalf("got=%d calls after stop, want<=4", n)
+	}
+}
+
+func TestContactSheet(t *testing.T) {
+	for _, table := range []struct{ n, cols int }{{0, 1}, {1, 1}, {4, 2}, {5, 3}, {10, 4}} {
+		if got := sheetColumns(table.n); got != table.cols {
+			t.Fatalf("sheetColumns(%d): got=%d, want=%d", table.n, got, table.cols)
+		}
+	}
+
+	const size = 10
+	for i := 0; i < 5; i++ {
+		o := cellOrigin(i, 3, size)
+		if got := cellAt(o.Add(image.Pt(size-1, 0)), 3, 5, size); got != i {
+			t.Fatalf("cellAt(%v): got=%d, want=%d", o, got, i)
+		}
+		if got := cellAt(o.Add(image.Pt(size, 0)), 3, 5, size); got != -1 {
+			t.Fatalf("cellAt in the margin: got=%d, want=-1", got)
+		}
+	}
+	if got := cellAt(cellOrigin(5, 3, size), 3, 5, size); got != -1 {
+		t.Fatalf("cellAt past the last cell: got=%d, want=-1", got)
+	}
+
+	thumb := image.NewRGBA(image.Rect(0, 0, size, size/2))
+	for i := range thumb.Pix {
+		thumb.Pix[i] = 255
+	}
+	sheet := contactSheet([]*image.RGBA{thumb, nil, thumb}, 2, size)
+	if want := image.Rect(0, 0, 2*size+3*thumbMargin, 2*size+3*thumbMargin); sheet.Bounds() != want {
+		t.Fatalf("got=%v, want=%v", sheet.Bounds(), want)
+	}
+	// The third thumbnail is centred in the first cell of the second row.
+	o := cellOrigin(2, 2, size)
+	if c := sheet.RGBAAt(o.X, o.Y+size/4); c.R != 255 {
+		t.Fatalf("got=%v, want white", c)
+	}
+	if c := sheet.RGBAAt(o.X, o.Y); c != sheetBackground {
+		t.Fatalf("got=%v, want the background", c)
+	}
+}
@@ -0,0 +1,514 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/bits"
+	"strings"
+
+	"github.com/saimn/fitsio"
+)
+
+// Tile-compressed images are stored in binary tables, following the
+// "Tiled Image Compression Convention" used by fpack and CFITSIO.
+
+// isTileCompressed reports whether the header describes a tile-compressed
+// image.
+func isTileCompressed(hdr *fitsio.Header) bool {
+	card := hdr.Get("ZIMAGE")
+	if card == nil {
+		return false
+	}
+	v, ok := card.Value.(bool)
+	return ok && v
+}
+
+// uncompressImage decodes the tile-compressed image stored in the table
+// into a big-endian data array, as it would be stored in an image HDU,
+// and returns it with its axes and data format. Quantized floating point
+// images are returned as BITPIX=-64 arrays.
+func uncompressImage(tbl *fitsio.Table) ([]byte, []int, dataFormat, error) {
+	hdr := tbl.Header()
+	zbitpix := int(headerFloat(hdr, "ZBITPIX", 0))
+	znaxis := int(headerFloat(hdr, "ZNAXIS", 0))
+	if znaxis == 0 {
+		return nil, nil, dataFormat{}, errors.New("compressed image has no axes")
+	}
+	axes := make([]int, znaxis)
+	tile := make([]int, znaxis)
+	npix := 1
+	for i := range axes {
+		axes[i] = int(headerFloat(hdr, fmt.Sprintf("ZNAXIS%d", i+1), 0))
+		def := 1.0
+		if i == 0 {
+			// Tiles are rows by default.
+			def = float64(axes[0])
+		}
+		tile[i] = int(headerFloat(hdr, fmt.Sprintf("ZTILE%d", i+1), def))
+		npix *= axes[i]
+	}
+
+	zp := tileParams{
+		cmptype:   strings.TrimSpace(headerString(hdr, "ZCMPTYPE")),
+		bitpix:    zbitpix,
+		blocksize: 32,
+		bytepix:   4,
+	}
+	for i := 1; hdr.Get(fmt.Sprintf("ZNAME%d", i)) != nil; i++ {
+		name := strings.TrimSpace(headerString(hdr, fmt.Sprintf("ZNAME%d", i)))
+		val := int(headerFloat(hdr, fmt.Sprintf("ZVAL%d", i), 0))
+		switch name {
+		case "BLOCKSIZE":
+			zp.blocksize = val
+		case "BYTEPIX":
+			zp.bytepix = val
+		}
+	}
+
+	// Floating point images are usually quantized to 32-bit integers,
+	// with a scale and a zero per tile.
+	quantized := zbitpix < 0 && (tbl.Index("ZSCALE") >= 0 || hdr.Get("ZSCALE") != nil)
+	q := quantizer{
+		method: strings.TrimSpace(headerString(hdr, "ZQUANTIZ")),
+		dither: int(headerFloat(hdr, "ZDITHER0", 1)),
+		zscale: headerFloat(hdr, "ZSCALE", 1),
+		zzero:  headerFloat(hdr, "ZZERO", 0),
+	}
+	if card := hdr.Get("ZBLANK"); card != nil {
+		v := int64(headerFloat(hdr, "ZBLANK", 0))
+		q.zblank = &v
+	}
+
+	df := dataFormat{
+		bitpix: zbitpix,
+		bscale: headerFloat(hdr, "BSCALE", 1),
+		bzero:  headerFloat(hdr, "BZERO", 0),
+		blank:  q.zblank,
+	}
+	if quantized {
+		zp.bitpix = 32
+		df = dataFormat{bitpix: -64, bscale: 1}
+	}
+	size := df.bitpix / 8
+	if size < 0 {
+		size = -size
+	}
+	out := make([]byte, npix*size)
+
+	rows, err := tbl.Read(0, tbl.NumRows())
+	if err != nil {
+		return nil, nil, df, err
+	}
+	defer rows.Close()
+
+	for row := 0; rows.Next(); row++ {
+		cols := make(map[string]interface{})
+		if err := rows.Scan(&cols); err != nil {
+			return nil, nil, df, err
+		}
+
+		start, shape, err := tileGrid(axes, tile, row)
+		if err != nil {
+			return nil, nil, df, err
+		}
+		n := 1
+		for _, s := range shape {
+			n *= s
+		}
+
+		var values []float64
+		data, _ := cols["COMPRESSED_DATA"].([]byte)
+		if len(data) == 0 {
+			// Tiles which could not be quantized are gzip compressed.
+			data, _ = cols["GZIP_COMPRESSED_DATA"].([]byte)
+			if len(data) == 0 {
+				return nil, nil, df, fmt.Errorf("tile %d: no compressed data", row+1)
+			}
+			values, err = decodeTile(tileParams{cmptype: "GZIP_1", bitpix: zbitpix}, data, n)
+			if err != nil {
+				return nil, nil, df, fmt.Errorf("tile %d: %v", row+1, err)
+			}
+		} else {
+			values, err = decodeTile(zp, data, n)
+			if err != nil {
+				return nil, nil, df, fmt.Errorf("tile %d: %v", row+1, err)
+			}
+			if quantized {
+				tq := q
+				if v, ok := cols["ZSCALE"].(float64); ok {
+					tq.zscale = v
+				}
+				if v, ok := cols["ZZERO"].(float64); ok {
+					tq.zzero = v
+				}
+				if v, ok := cols["ZBLANK"].(int32); ok {
+					blank := int64(v)
+					tq.zblank = &blank
+				}
+				tq.dequantize(values, row)
+			}
+		}
+
+		placeTile(out, df.bitpix, axes, start, shape, values)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, df, err
+	}
+
+	return out, axes, df, nil
+}
+
+// headerString returns the string value of the header keyword key.
+func headerString(hdr *fitsio.Header, key string) string {
+	card := hdr.Get(key)
+	if card == nil {
+		return ""
+	}
+	s, _ := card.Value.(string)
+	return s
+}
+
+// tileGrid returns the first pixel and the shape of the tile number row
+// of an image with the given axes and tile shape. Tiles are numbered
+// with the first axis varying fastest, like pixels.
+func tileGrid(axes, tile []int, row int) (start, shape []int, err error) {
+	start = make([]int, len(axes))
+	shape = make([]int, len(axes))
+	for i := range axes {
+		if tile[i] <= 0 {
+			return nil, nil, fmt.Errorf("invalid ZTILE%d=%d", i+1, tile[i])
+		}
+		ntiles := (axes[i] + tile[i] - 1) / tile[i]
+		start[i] = (row % ntiles) * tile[i]
+		row /= ntiles
+		shape[i] = tile[i]
+		if start[i]+shape[i] > axes[i] {
+			shape[i] = axes[i] - start[i]
+		}
+	}
+	if row != 0 {
+		return nil, nil, errors.New("too many tiles")
+	}
+	return start, shape, nil
+}
+
+// placeTile writes the values of a tile in the big-endian data array out
+// of type bitpix.
+func placeTile(out []byte, bitpix int, axes, start, shape []int, values []float64) {
+	size := bitpix / 8
+	if size < 0 {
+		size = -size
+	}
+	idx := make([]int, len(shape)) // position in the tile
+	for _, v := range values {
+		// Offset of the pixel in the image.
+		off, stride := 0, 1
+		for i := range axes {
+			off += (start[i] + idx[i]) * stride
+			stride *= axes[i]
+		}
+		putValue(out[off*size:], bitpix, v)
+
+		for i := range idx {
+			idx[i]++
+			if idx[i] < shape[i] {
+				break
+			}
+			idx[i] = 0
+		}
+	}
+}
+
+// putValue encodes v at the beginning of a big-endian data array of type
+// bitpix.
+func putValue(b []byte, bitpix int, v float64) {
+	switch bitpix {
+	case 8:
+		b[0] = uint8(v)
+	case 16:
+		binary.BigEndian.PutUint16(b, uint16(int16(v)))
+	case 32:
+		binary.BigEndian.PutUint32(b, uint32(int32(v)))
+	case 64:
+		binary.BigEndian.PutUint64(b, uint64(int64(v)))
+	case -32:
+		binary.BigEndian.PutUint32(b, math.Float32bits(float32(v)))
+	case -64:
+		binary.BigEndian.PutUint64(b, math.Float64bits(v))
+	}
+}
+
+// tileParams describes the compression of the tiles.
+type tileParams struct {
+	cmptype   string // ZCMPTYPE
+	bitpix    int    // type of the compressed values
+	blocksize int    // Rice block size
+	bytepix   int    // Rice bytes per pixel
+}
+
+// decodeTile uncompresses the n values of a tile.
+func decodeTile(zp tileParams, data []byte, n int) ([]float64, error) {
+	var raw []byte
+	switch zp.cmptype {
+	case "RICE_1", "RICE_ONE":
+		ints, err := riceDecode(data, n, zp.blocksize, zp.bytepix)
+		if err != nil {
+			return nil, err
+		}
+		values := make([]float64, n)
+		for i, v := range ints {
+			values[i] = float64(v)
+		}
+		return values, nil
+
+	case "GZIP_1", "GZIP_2":
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		raw, err = ioutil.ReadAll(zr)
+		if err != nil {
+			return nil, err
+		}
+		if zp.cmptype == "GZIP_2" {
+			raw = unshuffle(raw, abs(zp.bitpix)/8)
+		}
+
+	case "NOCOMPRESS":
+		raw = data
+
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", zp.cmptype)
+	}
+
+	size := abs(zp.bitpix) / 8
+	if len(raw) < n*size {
+		return nil, fmt.Errorf("truncated tile: %d bytes, want %d", len(raw), n*size)
+	}
+	values := make([]float64, n)
+	for i := range values {
+		if zp.bitpix > 0 {
+			values[i] = float64(rawInt(raw, zp.bitpix, i))
+		} else {
+			values[i] = pixelValue(raw, zp.bitpix, i)
+		}
+	}
+	return values, nil
+}
+
+// unshuffle reverts the byte shuffling of GZIP_2, where the most
+// significant bytes of all the values are stored first.
+func unshuffle(raw []byte, size int) []byte {
+	if size <= 1 {
+		return raw
+	}
+	n := len(raw) / size
+	out := make([]byte, len(raw))
+	for i := 0; i < n; i++ {
+		for j := 0; j < size; j++ {
+			out[i*size+j] = raw[j*n+i]
+		}
+	}
+	return out
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// riceDecode decodes n pixels of bytepix bytes from the Rice compressed
+// stream c, as done by fits_rdecomp in CFITSIO.
+func riceDecode(c []byte, n, blocksize, bytepix int) ([]int64, error) {
+	var fsbits, fsmax, bbits int
+	switch bytepix {
+	case 1:
+		fsbits, fsmax, bbits = 3, 6, 8
+	case 2:
+		fsbits, fsmax, bbits = 4, 14, 16
+	case 4:
+		fsbits, fsmax, bbits = 5, 25, 32
+	default:
+		return nil, fmt.Errorf("invalid Rice BYTEPIX %d", bytepix)
+	}
+	if blocksize <= 0 {
+		return nil, fmt.Errorf("invalid Rice BLOCKSIZE %d", blocksize)
+	}
+	if len(c) < bytepix+1 {
+		return nil, errors.New("truncated Rice stream")
+	}
+	mask := uint32(1<<uint(bbits) - 1)
+
+	// The first pixel is stored as is.
+	var lastpix uint32
+	for _, v := range c[:bytepix] {
+		lastpix = lastpix<<8 | uint32(v)
+	}
+	pos := bytepix
+	truncated := false
+	next := func() uint32 {
+		if pos >= len(c) {
+			truncated = true
+			return 0
+		}
+		pos++
+		return uint32(c[pos-1])
+	}
+
+	// b holds the nbits not yet used of the current bytes.
+	b := next()
+	nbits := 8
+
+	out := make([]int64, n)
+	for i := 0; i < n; i += blocksize {
+		imax := i + blocksize
+		if imax > n {
+			imax = n
+		}
+
+		// Number of bits of the split, for the block.
+		nbits -= fsbits
+		for nbits < 0 {
+			b = b<<8 | next()
+			nbits += 8
+		}
+		fs := int(b>>uint(nbits)) - 1
+		b &= 1<<uint(nbits) - 1
+
+		for j := i; j < imax; j++ {
+			var diff uint32
+			switch {
+			case fs < 0:
+				// Low entropy block: all the differences are zero.
+
+			case fs == fsmax:
+				// High entropy block: the differences are stored as is.
+				k := bbits - nbits
+				diff = b << uint(k)
+				for k -= 8; k >= 0; k -= 8 {
+					b = next()
+					diff |= b << uint(k)
+				}
+				if nbits > 0 {
+					b = next()
+					diff |= b >> uint(-k)
+					b &= 1<<uint(nbits) - 1
+				} else {
+					b = 0
+				}
+
+			default:
+				// Count the leading zeros, then read the fs low bits.
+				for b == 0 && !truncated {
+					nbits += 8
+					b = next()
+				}
+				nzero := nbits - bits.Len32(b)
+				nbits -= nzero + 1
+				b ^= 1 << uint(nbits)
+				nbits -= fs
+				for nbits < 0 {
+					b = b<<8 | next()
+					nbits += 8
+				}
+				diff = uint32(nzero)<<uint(fs) | b>>uint(nbits)
+				b &= 1<<uint(nbits) - 1
+			}
+			if truncated {
+				return nil, errors.New("truncated Rice stream")
+			}
+
+			// Undo the mapping of the differences to positive values.
+			if diff&1 == 0 {
+				diff >>= 1
+			} else {
+				diff = ^(diff >> 1)
+			}
+			lastpix = (diff + lastpix) & mask
+
+			switch bytepix {
+			case 1:
+				out[j] = int64(uint8(lastpix))
+			case 2:
+				out[j] = int64(int16(lastpix))
+			case 4:
+				out[j] = int64(int32(lastpix))
+			}
+		}
+	}
+
+	return out, nil
+}
+
+// quantizer converts the quantized integers of a floating point image
+// back to floating point values.
+type quantizer struct {
+	method string  // ZQUANTIZ
+	dither int     // ZDITHER0
+	zscale float64 // ZSCALE
+	zzero  float64 // ZZERO
+	zblank *int64  // ZBLANK
+}
+
+const (
+	nullValue = -2147483647 // quantized value of NaNs
+	zeroValue = -2147483646 // quantized value of zeros, with SUBTRACTIVE_DITHER_2
+)
+
+// dequantize converts in place the values of the tile number row.
+func (q quantizer) dequantize(values []float64, row int) {
+	dither := q.method == "SUBTRACTIVE_DITHER_1" || q.method == "SUBTRACTIVE_DITHER_2"
+
+	var iseed, next int
+	if dither {
+		iseed = (row + q.dither - 1) % nRandom
+		next = int(randomValues[iseed] * 500)
+	}
+
+	for i, v := range values {
+		iv := int64(v)
+		switch {
+		case q.zblank != nil && iv == *q.zblank, dither && iv == nullValue:
+			values[i] = math.NaN()
+		case q.method == "SUBTRACTIVE_DITHER_2" && iv == zeroValue:
+			values[i] = 0
+		case dither:
+			values[i] = (v-randomValues[next]+0.5)*q.zscale + q.zzero
+		default:
+			values[i] = v*q.zscale + q.zzero
+		}
+
+		// The random sequence advances for every pixel, blank or not.
+		if dither {
+			next++
+			if next == nRandom {
+				iseed = (iseed + 1) % nRandom
+				next = int(randomValues[iseed] * 500)
+			}
+		}
+	}
+}
+
+// nRandom is the number of random values used for dithering.
+const nRandom = 10000
+
+// randomValues are the uniform random numbers of fits_init_randoms in
+// CFITSIO, from the Park & Miller minimal standard generator.
+var randomValues = func() []float64 {
+	const a, m = 16807.0, 2147483647.0
+	seed := 1.0
+	values := make([]float64, nRandom)
+	for i := range values {
+		temp := a * seed
+		seed = temp - m*float64(int64(temp/m))
+		values[i] = seed / m
+	}
+	return values
+}()
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// riceEncode is the Rice compression of fits_rcomp in CFITSIO.
+func riceEncode(a []int64, blocksize, bytepix int) []byte {
+	var fsbits, fsmax, bbits uint
+	switch bytepix {
+	case 1:
+		fsbits, fsmax, bbits = 3, 6, 8
+	case 2:
+		fsbits, fsmax, bbits = 4, 14, 16
+	case 4:
+		fsbits, fsmax, bbits = 5, 25, 32
+	}
+	mask := uint64(1)<<bbits - 1
+
+	var out []byte
+	var acc uint64 // pending bits
+	var nacc uint
+	put := func(v uint64, n uint) {
+		for i := int(n) - 1; i >= 0; i-- {
+			acc = acc<<1 | (v>>uint(i))&1
+			nacc++
+			if nacc == 8 {
+				out = append(out, byte(acc))
+				acc, nacc = 0, 0
+			}
+		}
+	}
+
+	lastpix := uint64(a[0]) & mask
+	put(lastpix, bbits)
+	for i := 0; i < len(a); i += blocksize {
+		end := i + blocksize
+		if end > len(a) {
+			end = len(a)
+		}
+		diff := make([]uint64, end-i)
+		var sum float64
+		for j := range diff {
+			next := uint64(a[i+j]) & mask
+			// Signed difference, on bbits bits.
+			d := int64((next-lastpix)&mask<<(64-bbits)) >> (64 - bbits)
+			if d < 0 {
+				diff[j] = uint64(^(d << 1)) & mask
+			} else {
+				diff[j] = uint64(d<<1) & mask
+			}
+			sum += float64(diff[j])
+			lastpix = next
+		}
+
+		dpsum := (sum - float64(len(diff)/2) - 1) / float64(len(diff))
+		if dpsum < 0 {
+			dpsum = 0
+		}
+		psum := uint64(dpsum) >> 1
+		fs := uint(0)
+		for ; psum > 0; fs++ {
+			psum >>= 1
+		}
+
+		switch {
+		case fs >= fsmax:
+			put(uint64(fsmax+1), fsbits)
+			for _, v := range diff {
+				put(v, bbits)
+			}
+		case fs == 0 && sum == 0:
+			put(0, fsbits)
+		default:
+			put(uint64(fs+1), fsbits)
+			for _, v := range diff {
+				for top := v >> fs; top > 0; top-- {
+					put(0, 1)
+				}
+				put(1, 1)
+				if fs > 0 {
+					put(v&(1<<fs-1), fs)
+				}
+			}
+		}
+	}
+	if nacc > 0 {
+		put(0, 8-nacc)
+	}
+	return out
+}
+
+func TestRiceDecode(t *testing.T) {
+	rnd := rand.New(rand.NewSource(42))
+	for _, bytepix := range []int{1, 2, 4} {
+		bbits := uint(8 * bytepix)
+		lo, hi := -int64(1)<<(bbits-1), int64(1)<<(bbits-1)-1
+		if bytepix == 1 {
+			lo, hi = 0, 255
+		}
+		clamp := func(v int64) int64 {
+			if v < lo {
+				return lo
+			}
+			if v > hi {
+				return hi
+			}
+			return v
+		}
+
+		// A constant block, a noisy block and a block of random values.
+		var want []int64
+		for i := 0; i < 40; i++ {
+			want = append(want, clamp(100))
+		}
+		for i := 0; i < 50; i++ {
+			want = append(want, clamp(100+int64(rnd.NormFloat64()*5)))
+		}
+		for i := 0; i < 37; i++ {
+			want = append(want, lo+rnd.Int63n(hi-lo+1))
+		}
+
+		got, err := riceDecode(riceEncode(want, 32, bytepix), len(want), 32, bytepix)
+		if err != nil {
+			t.Fatalf("bytepix=%d: %v", bytepix, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("bytepix=%d: invalid values\ngot =%v\nwant=%v\n", bytepix, got, want)
+		}
+
+		if _, err := riceDecode(riceEncode(want, 32, bytepix)[:20], len(want), 32, bytepix); err == nil {
+			t.Fatalf("bytepix=%d: expected an error for a truncated stream", bytepix)
+		}
+	}
+}
+
+func TestDecodeTile(t *testing.T) {
+	want := []float64{-3, 0, 7, 32767}
+	raw := make([]byte, 8)
+	for i, v := range want {
+		binary.BigEndian.PutUint16(raw[2*i:], uint16(int16(v)))
+	}
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(raw)
+	zw.Close()
+
+	got, err := decodeTile(tileParams{cmptype: "GZIP_1", bitpix: 16}, gz.Bytes(), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("GZIP_1: got=%v, want=%v", got, want)
+	}
+
+	// GZIP_2 stores the most significant bytes first.
+	shuffled := []byte{raw[0], raw[2], raw[4], raw[6], raw[1], raw[3], raw[5], raw[7]}
+	gz.Reset()
+	zw = gzip.NewWriter(&gz)
+	zw.Write(shuffled)
+	zw.Close()
+	got, err = decodeTile(tileParams{cmptype: "GZIP_2", bitpix: 16}, gz.Bytes(), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("GZIP_2: got=%v, want=%v", got, want)
+	}
+
+	ints := []int64{-3, 0, 7, 32767}
+	rice := riceEncode(ints, 32, 2)
+	got, err = decodeTile(tileParams{cmptype: "RICE_1", blocksize: 32, bytepix: 2}, rice, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("RICE_1: got=%v, want=%v", got, want)
+	}
+
+	if _, err := decodeTile(tileParams{cmptype: "HCOMPRESS_1"}, nil, 4); err == nil {
+		t.Fatalf("expected an error for an unsupported compression")
+	}
+}
+
+func TestTiles(t *testing.T) {
+	axes := []int{5, 3}
+	tile := []int{2, 2}
+	out := make([]byte, 5*3)
+	for row := 0; row < 6; row++ {
+		start, shape, err := tileGrid(axes, tile, row)
+		if err != nil {
+			t.Fatal(err)
+		}
+		values := make([]float64, shape[0]*shape[1])
+		for i := range values {
+			values[i] = float64(row + 1)
+		}
+		placeTile(out, 8, axes, start, shape, values)
+	}
+	want := []byte{
+		1, 1, 2, 2, 3,
+		1, 1, 2, 2, 3,
+		4, 4, 5, 5, 6,
+	}
+	if !bytes.Equal(out, want) {
+		t.Fatalf("invalid tiling\ngot =%v\nwant=%v\n", out, want)
+	}
+	if _, _, err := tileGrid(axes, tile, 6); err == nil {
+		t.Fatalf("expected an error for too many tiles")
+	}
+}
+
+func TestDequantize(t *testing.T) {
+	// The last seed of the CFITSIO random generator.
+	if seed := math.Round(randomValues[nRandom-1] * 2147483647); seed != 1043618065 {
+		t.Fatalf("invalid random sequence: last seed=%v", seed)
+	}
+
+	blank := int64(-2147483647)
+	q := quantizer{method: "NO_DITHER", zscale: 0.5, zzero: 10, zblank: &blank}
+	values := []float64{0, 4, -2147483647}
+	q.dequantize(values, 0)
+	if values[0] != 10 || values[1] != 12 || !math.IsNaN(values[2]) {
+		t.Fatalf("NO_DITHER: got=%v", values)
+	}
+
+	q = quantizer{method: "SUBTRACTIVE_DITHER_1", dither: 1, zscale: 0.5, zzero: 10}
+	values = []float64{0, 4, nullValue}
+	q.dequantize(values, 0)
+	r := randomValues[int(randomValues[0]*500):]
+	if values[0] != (0-r[0]+0.5)*0.5+10 || values[1] != (4-r[1]+0.5)*0.5+10 || !math.IsNaN(values[2]) {
+		t.Fatalf("SUBTRACTIVE_DITHER_1: got=%v", values)
+	}
+}
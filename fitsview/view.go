@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// zoomLevels are the image scales, in percents, used when zooming in and
+// out.
+var zoomLevels = []int{5, 10, 25, 33, 50, 75, 100, 150, 200, 300, 400, 600, 800, 1200, 1600, 3200}
+
+// zoomIn returns the zoom level following scale.
+func zoomIn(scale int) int {
+	for _, z := range zoomLevels {
+		if z > scale {
+			return z
+		}
+	}
+	return zoomLevels[len(zoomLevels)-1]
+}
+
+// zoomOut returns the zoom level preceding scale.
+func zoomOut(scale int) int {
+	for i := len(zoomLevels) - 1; i >= 0; i-- {
+		if zoomLevels[i] < scale {
+			return zoomLevels[i]
+		}
+	}
+	return zoomLevels[0]
+}
+
+// fitToWindow sets the scale and origin of the image so that it fits
+// and is centred in a width x height window.
+func (img *imageInfo) fitToWindow(width, height int) {
+	dx, dy := img.Bounds().Dx(), img.Bounds().Dy()
+	if dx == 0 || dy == 0 || width <= 0 || height <= 0 {
+		return
+	}
+	img.scale = int(math.Min(float64(width*100)/float64(dx), float64(height*100)/float64(dy)))
+	if img.scale < 1 {
+		img.scale = 1
+	}
+	img.orig = image.Point{
+		X: (dx - width*100/img.scale) / 2,
+		Y: (dy - height*100/img.scale) / 2,
+	}
+}
+
+// toImage converts window coordinates to image coordinates.
+func (img *imageInfo) toImage(x, y float64) (float64, float64) {
+	s := float64(img.scale) / 100
+	return float64(img.orig.X) + x/s, float64(img.orig.Y) + y/s
+}
+
+// zoomAt changes the scale of the image, keeping the image point under
+// the window coordinates (x, y) at the same place.
+func (img *imageInfo) zoomAt(scale int, x, y float64) {
+	ix, iy := img.toImage(x, y)
+	img.scale = scale
+	s := float64(scale) / 100
+	img.orig = image.Point{
+		X: int(math.Round(ix - x/s)),
+		Y: int(math.Round(iy - y/s)),
+	}
+	img.fit = false
+}
+
+// resampleMethods lists the available resampling methods.
+var resampleMethods = []string{"nearest", "bilinear"}
+
+// resample returns the width x height window of src seen with the given
+// scale (in percents) and origin. Pixels outside of src are transparent.
+func resample(src *image.RGBA, width, height, scale int, orig image.Point, method string) (*image.RGBA, error) {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	s := float64(scale) / 100
+	b := src.Bounds()
+
+	switch method {
+	case "nearest":
+		for y := 0; y < height; y++ {
+			sy := int(math.Floor(float64(orig.Y) + (float64(y)+0.5)/s))
+			if sy < b.Min.Y || sy >= b.Max.Y {
+				continue
+			}
+			for x := 0; x < width; x++ {
+				sx := int(math.Floor(float64(orig.X) + (float64(x)+0.5)/s))
+				if sx < b.Min.X || sx >= b.Max.X {
+					continue
+				}
+				i := dst.PixOffset(x, y)
+				j := src.PixOffset(sx, sy)
+				copy(dst.Pix[i:i+4], src.Pix[j:j+4])
+			}
+		}
+
+	case "bilinear":
+		clamp := func(v, lo, hi int) int {
+			if v < lo {
+				return lo
+			}
+			if v > hi {
+				return hi
+			}
+			return v
+		}
+		for y := 0; y < height; y++ {
+			fy := float64(orig.Y) + (float64(y)+0.5)/s - 0.5
+			if fy < float64(b.Min.Y)-0.5 || fy > float64(b.Max.Y)-0.5 {
+				continue
+			}
+			y0 := int(math.Floor(fy))
+			ty := fy - float64(y0)
+			y1 := clamp(y0+1, b.Min.Y, b.Max.Y-1)
+			y0 = clamp(y0, b.Min.Y, b.Max.Y-1)
+			for x := 0; x < width; x++ {
+				fx := float64(orig.X) + (float64(x)+0.5)/s - 0.5
+				if fx < float64(b.Min.X)-0.5 || fx > float64(b.Max.X)-0.5 {
+					continue
+				}
+				x0 := int(math.Floor(fx))
+				tx := fx - float64(x0)
+				x1 := clamp(x0+1, b.Min.X, b.Max.X-1)
+				x0 = clamp(x0, b.Min.X, b.Max.X-1)
+
+				i := dst.PixOffset(x, y)
+				p00 := src.PixOffset(x0, y0)
+				p10 := src.PixOffset(x1, y0)
+				p01 := src.PixOffset(x0, y1)
+				p11 := src.PixOffset(x1, y1)
+				for c := 0; c < 4; c++ {
+					v := (1-tx)*(1-ty)*float64(src.Pix[p00+c]) +
+						tx*(1-ty)*float64(src.Pix[p10+c]) +
+						(1-tx)*ty*float64(src.Pix[p01+c]) +
+						tx*ty*float64(src.Pix[p11+c])
+					dst.Pix[i+c] = uint8(v + 0.5)
+				}
+			}
+		}
+
+	default:
+		return nil, fmt.Errorf("unknown resampling method %q", method)
+	}
+
+	return dst, nil
+}
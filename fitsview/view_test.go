@@ -0,0 +1,84 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestZoomLevels(t *testing.T) {
+	if got := zoomIn(100); got != 150 {
+		t.Fatalf("zoomIn(100)=%d, want 150", got)
+	}
+	if got := zoomOut(100); got != 75 {
+		t.Fatalf("zoomOut(100)=%d, want 75", got)
+	}
+	if got := zoomIn(123); got != 150 {
+		t.Fatalf("zoomIn(123)=%d, want 150", got)
+	}
+	if got := zoomOut(5); got != 5 {
+		t.Fatalf("zoomOut(5)=%d, want 5", got)
+	}
+}
+
+func TestZoomAt(t *testing.T) {
+	img := &imageInfo{
+		hduDesc: hduDesc{axes: []int{100, 100}, planes: 1},
+		scale:   100,
+		fit:     true,
+	}
+	x, y := 40.0, 60.0
+	ix, iy := img.toImage(x, y)
+	img.zoomAt(400, x, y)
+	jx, jy := img.toImage(x, y)
+	if math.Abs(ix-jx) > 1 || math.Abs(iy-jy) > 1 || img.fit {
+		t.Fatalf("zoomAt moved the pointed pixel: (%v, %v) -> (%v, %v)", ix, iy, jx, jy)
+	}
+
+	img.fitToWindow(50, 200)
+	if img.scale != 50 || img.orig != (image.Point{0, -150}) {
+		t.Fatalf("invalid fit: scale=%d, orig=%v", img.scale, img.orig)
+	}
+}
+
+func TestResample(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	src.SetRGBA(0, 0, color.RGBA{0, 0, 0, 255})
+	src.SetRGBA(1, 0, color.RGBA{200, 200, 200, 255})
+
+	dst, err := resample(src, 4, 2, 200, image.Point{}, "nearest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for x, want := range []uint8{0, 0, 200, 200} {
+		if c := dst.RGBAAt(x, 1); c.R != want {
+			t.Fatalf("nearest: pixel %d: got=%v, want=%d", x, c, want)
+		}
+	}
+	if c := dst.RGBAAt(0, 1); c.A != 255 {
+		t.Fatalf("nearest: pixel inside the image is not opaque")
+	}
+
+	dst, err = resample(src, 4, 1, 200, image.Point{}, "bilinear")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for x, want := range []uint8{0, 50, 150, 200} {
+		if c := dst.RGBAAt(x, 0); c.R != want {
+			t.Fatalf("bilinear: pixel %d: got=%v, want=%d", x, c, want)
+		}
+	}
+
+	dst, err = resample(src, 2, 1, 100, image.Point{X: 1}, "nearest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := dst.RGBAAt(1, 0); c.A != 0 {
+		t.Fatalf("pixel outside of the image is not transparent: %v", c)
+	}
+
+	if _, err := resample(src, 2, 1, 100, image.Point{}, "foo"); err == nil {
+		t.Fatalf("expected an error for an unknown method")
+	}
+}
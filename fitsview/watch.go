@@ -0,0 +1,129 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// isFITSName reports whether fname has a FITS extension, possibly
+// followed by a compression extension.
+func isFITSName(fname string) bool {
+	ext := strings.ToLower(filepath.Ext(fname))
+	switch ext {
+	case ".gz", ".bz2", ".fz":
+		fname = strings.TrimSuffix(fname, filepath.Ext(fname))
+		ext = strings.ToLower(filepath.Ext(fname))
+	}
+	switch ext {
+	case ".fits", ".fit", ".fts":
+		return true
+	}
+	return false
+}
+
+// listFITS returns the FITS files of the directory dir, sorted by
+// modification time.
+func listFITS(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	type file struct {
+		name  string
+		mtime time.Time
+	}
+	var files []file
+	for _, e := range entries {
+		if e.IsDir() || !isFITSName(e.Name()) {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, file{filepath.Join(dir, e.Name()), fi.ModTime()})
+	}
+	sort.SliceStable(files, func(i, j int) bool { return files[i].mtime.Before(files[j].mtime) })
+	names := make([]string, len(files))
+	for i, f := range files {
+		names[i] = f.name
+	}
+	return names, nil
+}
+
+// watchDir sends the FITS files written to the directory dir on the
+// returned channel, once they are complete. It uses inotify when
+// available, and polls the directory every interval otherwise.
+func watchDir(dir string, interval time.Duration) (<-chan string, error) {
+	if _, err := os.ReadDir(dir); err != nil {
+		return nil, err
+	}
+	files := make(chan string)
+	err := notifyDir(dir, files)
+	if err != nil {
+		log.Printf("could not watch %s, polling it: %v\n", dir, err)
+		go pollDir(dir, interval, files)
+	}
+	return files, nil
+}
+
+// pollDir polls the directory dir every interval and sends the new FITS
+// files on files.
+func pollDir(dir string, interval time.Duration, files chan<- string) {
+	p := newPoller(dir)
+	for {
+		for _, name := range p.poll() {
+			files <- name
+		}
+		time.Sleep(interval)
+	}
+}
+
+// poller finds the new files of a directory. The files being written
+// are ignored until their size stops changing between two polls.
+type poller struct {
+	dir  string
+	size map[string]int64 // size of the files seen at the last poll, -1 once sent
+}
+
+// newPoller returns a poller of dir, which ignores the files already in
+// the directory.
+func newPoller(dir string) *poller {
+	p := &poller{dir: dir, size: make(map[string]int64)}
+	names, _ := listFITS(dir)
+	for _, name := range names {
+		p.size[name] = -1
+	}
+	return p
+}
+
+// poll returns the files which are complete since the last poll, sorted
+// by modification time.
+func (p *poller) poll() []string {
+	names, err := listFITS(p.dir)
+	if err != nil {
+		log.Printf("could not list %s: %v\n", p.dir, err)
+		return nil
+	}
+	var done []string
+	for _, name := range names {
+		fi, err := os.Stat(name)
+		if err != nil {
+			continue
+		}
+		size, seen := p.size[name]
+		switch {
+		case size < 0:
+		case seen && size == fi.Size():
+			done = append(done, name)
+			p.size[name] = -1
+		default:
+			p.size[name] = fi.Size()
+		}
+	}
+	return done
+}
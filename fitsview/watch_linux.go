@@ -0,0 +1,50 @@
+package main
+
+import (
+	"log"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+// notifyDir sends the FITS files closed after writing in dir, or moved
+// into it, on files. The directory is watched with inotify.
+func notifyDir(dir string, files chan<- string) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return err
+	}
+	if _, err := syscall.InotifyAddWatch(fd, dir, syscall.IN_CLOSE_WRITE|syscall.IN_MOVED_TO); err != nil {
+		syscall.Close(fd)
+		return err
+	}
+
+	go func() {
+		defer syscall.Close(fd)
+		buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+		for {
+			n, err := syscall.Read(fd, buf)
+			if err == syscall.EINTR {
+				continue
+			}
+			if err != nil || n <= 0 {
+				log.Printf("could not watch %s: %v\n", dir, err)
+				return
+			}
+			for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+				ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+				name := buf[off+syscall.SizeofInotifyEvent : off+syscall.SizeofInotifyEvent+int(ev.Len)]
+				off += syscall.SizeofInotifyEvent + int(ev.Len)
+
+				// The name is padded with NUL bytes.
+				for len(name) > 0 && name[len(name)-1] == 0 {
+					name = name[:len(name)-1]
+				}
+				if ev.Mask&syscall.IN_ISDIR == 0 && isFITSName(string(name)) {
+					files <- filepath.Join(dir, string(name))
+				}
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// notifyDir is only implemented with inotify, the directories are polled
+// on other systems.
+func notifyDir(dir string, files chan<- string) error {
+	return errors.New("no file notification on this system")
+}
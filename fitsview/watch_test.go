@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestIsFITSName(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		want bool
+	}{
+		{"img.fits", true},
+		{"IMG.FIT", true},
+		{"img.fts.gz", true},
+		{"img.fits.fz", true},
+		{"img.fits.tmp", false},
+		{"img.gz", false},
+		{"notes.txt", false},
+	} {
+		if got := isFITSName(tc.name); got != tc.want {
+			t.Fatalf("isFITSName(%q): got=%v, want=%v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestPoller(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string, age time.Duration) string {
+		fname := filepath.Join(dir, name)
+		if err := os.WriteFile(fname, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		mtime := time.Now().Add(-age)
+		if err := os.Chtimes(fname, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		return fname
+	}
+
+	write("old.fits", "old", time.Hour)
+	p := newPoller(dir)
+	if got := p.poll(); got != nil {
+		t.Fatalf("existing files were returned: %v", got)
+	}
+
+	b := write("b.fits", "b", time.Minute)
+	a := write("a.fits", "a", 2*time.Minute)
+	write("notes.txt", "notes", 0)
+	if got := p.poll(); got != nil {
+		t.Fatalf("new files were returned before their size was known: %v", got)
+	}
+	// b is still being written.
+	write("b.fits", "bb", time.Minute)
+	if got, want := p.poll(), []string{a}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got=%v, want=%v", got, want)
+	}
+	if got, want := p.poll(), []string{b}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got=%v, want=%v", got, want)
+	}
+	if got := p.poll(); got != nil {
+		t.Fatalf("files were returned twice: %v", got)
+	}
+
+	names, err := listFITS(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{filepath.Join(dir, "old.fits"), a, b}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("got=%v, want=%v", names, want)
+	}
+}
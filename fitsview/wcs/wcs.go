@@ -0,0 +1,277 @@
+// Package wcs implements the FITS World Coordinate System for the
+// celestial axes of an image, as described in Greisen & Calabretta (2002)
+// and Calabretta & Greisen (2002).
+//
+// Only the gnomonic (TAN) projection is supported.
+package wcs
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/saimn/fitsio"
+)
+
+// ErrNoWCS is returned when the header does not describe celestial
+// coordinates.
+var ErrNoWCS = errors.New("wcs: no celestial WCS in header")
+
+// WCS is the world coordinate solution of the two first axes of an
+// image. Pixel coordinates follow the FITS convention: the centre of the
+// first pixel is (1, 1).
+type WCS struct {
+	CType [2]string     // axis types, e.g. "RA---TAN" and "DEC--TAN"
+	CRPix [2]float64    // reference pixel
+	CRVal [2]float64    // world coordinates of the reference pixel, in degrees
+	CD    [2][2]float64 // linear transformation matrix, in degrees per pixel
+
+	inv [2][2]float64 // inverse of CD
+}
+
+// New returns the WCS described by the header. The linear transformation
+// is read from the CDi_j keywords, or from PCi_j and CDELTi, or from
+// CDELTi and CROTA2, in this order.
+func New(hdr *fitsio.Header) (*WCS, error) {
+	var w WCS
+	for i := 0; i < 2; i++ {
+		card := hdr.Get(fmt.Sprintf("CTYPE%d", i+1))
+		if card == nil {
+			return nil, ErrNoWCS
+		}
+		ctype, ok := card.Value.(string)
+		if !ok {
+			return nil, fmt.Errorf("wcs: invalid CTYPE%d value %v", i+1, card.Value)
+		}
+		w.CType[i] = strings.TrimSpace(ctype)
+		w.CRPix[i] = float(hdr, fmt.Sprintf("CRPIX%d", i+1), 0)
+		w.CRVal[i] = float(hdr, fmt.Sprintf("CRVAL%d", i+1), 0)
+	}
+	if !strings.HasSuffix(w.CType[0], "-TAN") || !strings.HasSuffix(w.CType[1], "-TAN") {
+		return nil, fmt.Errorf("wcs: unsupported projection %q/%q", w.CType[0], w.CType[1])
+	}
+
+	switch {
+	case hdr.Get("CD1_1") != nil || hdr.Get("CD2_2") != nil:
+		for i := 0; i < 2; i++ {
+			for j := 0; j < 2; j++ {
+				w.CD[i][j] = float(hdr, fmt.Sprintf("CD%d_%d", i+1, j+1), 0)
+			}
+		}
+
+	default:
+		cdelt := [2]float64{float(hdr, "CDELT1", 1), float(hdr, "CDELT2", 1)}
+		pc := [2][2]float64{{1, 0}, {0, 1}}
+		if hdr.Get("PC1_1") != nil || hdr.Get("PC2_2") != nil {
+			for i := 0; i < 2; i++ {
+				for j := 0; j < 2; j++ {
+					pc[i][j] = float(hdr, fmt.Sprintf("PC%d_%d", i+1, j+1), pc[i][j])
+				}
+			}
+		} else if hdr.Get("CROTA2") != nil {
+			rho := float(hdr, "CROTA2", 0) * math.Pi / 180
+			// The PC matrix equivalent to CROTA2, see eq. 188 of
+			// Calabretta & Greisen (2002).
+			pc = [2][2]float64{
+				{math.Cos(rho), -math.Sin(rho) * cdelt[1] / cdelt[0]},
+				{math.Sin(rho) * cdelt[0] / cdelt[1], math.Cos(rho)},
+			}
+		}
+		for i := 0; i < 2; i++ {
+			for j := 0; j < 2; j++ {
+				w.CD[i][j] = cdelt[i] * pc[i][j]
+			}
+		}
+	}
+
+	det := w.CD[0][0]*w.CD[1][1] - w.CD[0][1]*w.CD[1][0]
+	if det == 0 {
+		return nil, errors.New("wcs: singular transformation matrix")
+	}
+	w.inv = [2][2]float64{
+		{w.CD[1][1] / det, -w.CD[0][1] / det},
+		{-w.CD[1][0] / det, w.CD[0][0] / det},
+	}
+
+	return &w, nil
+}
+
+// float returns the numerical value of the header keyword key, or def
+// if the keyword is missing.
+func float(hdr *fitsio.Header, key string, def float64) float64 {
+	card := hdr.Get(key)
+	if card == nil {
+		return def
+	}
+	switch v := card.Value.(type) {
+	case float64:
+		return v
+	case float32:
+		return float64(v)
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	}
+	return def
+}
+
+const deg = math.Pi / 180
+
+// PixelToWorld returns the world coordinates (e.g. RA and Dec), in
+// degrees, of the pixel (x, y).
+func (w *WCS) PixelToWorld(x, y float64) (float64, float64) {
+	dx, dy := x-w.CRPix[0], y-w.CRPix[1]
+	// Intermediate world coordinates, in radians.
+	xi := (w.CD[0][0]*dx + w.CD[0][1]*dy) * deg
+	eta := (w.CD[1][0]*dx + w.CD[1][1]*dy) * deg
+
+	ra0, dec0 := w.CRVal[0]*deg, w.CRVal[1]*deg
+	den := math.Cos(dec0) - eta*math.Sin(dec0)
+	ra := ra0 + math.Atan2(xi, den)
+	dec := math.Atan2(math.Sin(dec0)+eta*math.Cos(dec0), math.Hypot(xi, den))
+
+	ra = math.Mod(ra/deg, 360)
+	if ra < 0 {
+		ra += 360
+	}
+	return ra, dec / deg
+}
+
+// WorldToPixel returns the pixel coordinates of the world coordinates
+// (ra, dec), in degrees. ok is false if the position is on the other
+// hemisphere, where the projection is not defined.
+func (w *WCS) WorldToPixel(ra, dec float64) (x, y float64, ok bool) {
+	ra0, dec0 := w.CRVal[0]*deg, w.CRVal[1]*deg
+	ra, dec = ra*deg, dec*deg
+
+	cosc := math.Sin(dec0)*math.Sin(dec) + math.Cos(dec0)*math.Cos(dec)*math.Cos(ra-ra0)
+	if cosc <= 0 {
+		return 0, 0, false
+	}
+	xi := math.Cos(dec) * math.Sin(ra-ra0) / cosc / deg
+	eta := (math.Cos(dec0)*math.Sin(dec) - math.Sin(dec0)*math.Cos(dec)*math.Cos(ra-ra0)) / cosc / deg
+
+	x = w.inv[0][0]*xi + w.inv[0][1]*eta + w.CRPix[0]
+	y = w.inv[1][0]*xi + w.inv[1][1]*eta + w.CRPix[1]
+	return x, y, true
+}
+
+// PixelScale returns the mean size of a pixel, in degrees.
+func (w *WCS) PixelScale() float64 {
+	return math.Sqrt(math.Abs(w.CD[0][0]*w.CD[1][1] - w.CD[0][1]*w.CD[1][0]))
+}
+
+// FormatRA formats a right ascension in degrees as hours, minutes and
+// seconds.
+func FormatRA(ra float64) string {
+	ms := int64(math.Round(ra/15*3600*1000)) % (24 * 3600 * 1000)
+	if ms < 0 {
+		ms += 24 * 3600 * 1000
+	}
+	return fmt.Sprintf("%02d:%02d:%06.3f", ms/3600000, ms/60000%60, float64(ms%60000)/1000)
+}
+
+// FormatDec formats a declination in degrees as degrees, minutes and
+// seconds.
+func FormatDec(dec float64) string {
+	sign := "+"
+	if dec < 0 {
+		sign = "-"
+		dec = -dec
+	}
+	cs := int64(math.Round(dec * 3600 * 100))
+	return fmt.Sprintf("%s%02d:%02d:%05.2f", sign, cs/360000, cs/6000%60, float64(cs%6000)/100)
+}
+
+// ParseRA parses a right ascension given in degrees, or in hours,
+// minutes and seconds separated by colons or spaces.
+func ParseRA(s string) (float64, error) {
+	v, sexa, err := parseSexagesimal(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid right ascension %q", s)
+	}
+	if sexa {
+		v *= 15
+	}
+	return v, nil
+}
+
+// ParseDec parses a declination given in degrees, or in degrees, minutes
+// and seconds separated by colons or spaces.
+func ParseDec(s string) (float64, error) {
+	v, _, err := parseSexagesimal(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid declination %q", s)
+	}
+	return v, nil
+}
+
+// parseSexagesimal parses a decimal value or a sexagesimal value with two
+// or three fields. sexa reports whether the value was sexagesimal.
+func parseSexagesimal(s string) (v float64, sexa bool, err error) {
+	s = strings.TrimSpace(s)
+	fields := strings.FieldsFunc(s, func(r rune) bool { return r == ':' || r == ' ' })
+	if len(fields) == 1 {
+		v, err = strconv.ParseFloat(fields[0], 64)
+		return v, false, err
+	}
+	if len(fields) == 0 || len(fields) > 3 {
+		return 0, false, fmt.Errorf("invalid value")
+	}
+	for i, f := range fields {
+		x, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return 0, false, err
+		}
+		v += math.Abs(x) / math.Pow(60, float64(i))
+	}
+	if strings.HasPrefix(s, "-") {
+		v = -v
+	}
+	return v, true, nil
+}
+
+// Axis is the linear world coordinate of one image axis, e.g. the
+// spectral axis of a data cube.
+type Axis struct {
+	CType string  // axis type, e.g. "FREQ" or "VRAD"
+	CUnit string  // axis unit, e.g. "Hz" or "m/s"
+	CRPix float64 // reference pixel
+	CRVal float64 // world coordinate of the reference pixel
+	CDelt float64 // world coordinate increment per pixel
+}
+
+// NewAxis returns the linear world coordinate of the axis i (starting at
+// 1) described by the header. The increment is read from CDi_i, or from
+// CDELTi and PCi_i.
+func NewAxis(hdr *fitsio.Header, i int) (*Axis, error) {
+	card := hdr.Get(fmt.Sprintf("CTYPE%d", i))
+	if card == nil {
+		return nil, fmt.Errorf("wcs: no CTYPE%d in header", i)
+	}
+	ctype, _ := card.Value.(string)
+	a := Axis{
+		CType: strings.TrimSpace(ctype),
+		CRPix: float(hdr, fmt.Sprintf("CRPIX%d", i), 0),
+		CRVal: float(hdr, fmt.Sprintf("CRVAL%d", i), 0),
+	}
+	if card := hdr.Get(fmt.Sprintf("CUNIT%d", i)); card != nil {
+		cunit, _ := card.Value.(string)
+		a.CUnit = strings.TrimSpace(cunit)
+	}
+	cd := fmt.Sprintf("CD%d_%d", i, i)
+	if hdr.Get(cd) != nil {
+		a.CDelt = float(hdr, cd, 0)
+	} else {
+		a.CDelt = float(hdr, fmt.Sprintf("CDELT%d", i), 1) * float(hdr, fmt.Sprintf("PC%d_%d", i, i), 1)
+	}
+	return &a, nil
+}
+
+// Value returns the world coordinate of the pixel p (starting at 1).
+func (a *Axis) Value(p float64) float64 {
+	return a.CRVal + a.CDelt*(p-a.CRPix)
+}
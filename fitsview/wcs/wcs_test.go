@@ -0,0 +1,222 @@
+package wcs
+
+import (
+	"math"
+	"testing"
+
+	"github.com/saimn/fitsio"
+)
+
+func newHeader(cards ...fitsio.Card) *fitsio.Header {
+	return fitsio.NewHeader(cards, fitsio.IMAGE_HDU, -32, []int{100, 100})
+}
+
+func tanHeader(cards ...fitsio.Card) *fitsio.Header {
+	return newHeader(append([]fitsio.Card{
+		{Name: "CTYPE1", Value: "RA---TAN"},
+		{Name: "CTYPE2", Value: "DEC--TAN"},
+	}, cards...)...)
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(newHeader()); err != ErrNoWCS {
+		t.Fatalf("got err=%v, want %v", err, ErrNoWCS)
+	}
+	_, err := New(newHeader(
+		fitsio.Card{Name: "CTYPE1", Value: "RA---SIN"},
+		fitsio.Card{Name: "CTYPE2", Value: "DEC--SIN"},
+	))
+	if err == nil {
+		t.Fatalf("expected an error for an unsupported projection")
+	}
+}
+
+func TestPixelToWorld(t *testing.T) {
+	w, err := New(tanHeader(
+		fitsio.Card{Name: "CRPIX1", Value: 50},
+		fitsio.Card{Name: "CRPIX2", Value: 50.5},
+		fitsio.Card{Name: "CRVAL1", Value: 0.0},
+		fitsio.Card{Name: "CRVAL2", Value: 0.0},
+		fitsio.Card{Name: "CDELT1", Value: -0.1},
+		fitsio.Card{Name: "CDELT2", Value: 0.1},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, table := range []struct {
+		x, y    float64
+		ra, dec float64
+	}{
+		{x: 50, y: 50.5, ra: 0, dec: 0},
+		// 1 deg west on the equator: atan(pi/180) = 0.999898 deg.
+		{x: 60, y: 50.5, ra: 360 - 0.9998984, dec: 0},
+		{x: 40, y: 50.5, ra: 0.9998984, dec: 0},
+		{x: 50, y: 60.5, ra: 0, dec: 0.9998984},
+	} {
+		ra, dec := w.PixelToWorld(table.x, table.y)
+		if math.Abs(ra-table.ra) > 1e-6 || math.Abs(dec-table.dec) > 1e-6 {
+			t.Fatalf("(%v, %v): got=(%v, %v), want=(%v, %v)",
+				table.x, table.y, ra, dec, table.ra, table.dec)
+		}
+	}
+}
+
+func TestLinearTransformations(t *testing.T) {
+	const rho = 30.0
+	cos, sin := math.Cos(rho*deg), math.Sin(rho*deg)
+	ref := []fitsio.Card{
+		{Name: "CRPIX1", Value: 512},
+		{Name: "CRPIX2", Value: 512},
+		{Name: "CRVAL1", Value: 202.4696},
+		{Name: "CRVAL2", Value: 47.1952},
+	}
+	cdelt1, cdelt2 := -2.8e-4, 2.8e-4
+	headers := map[string]*fitsio.Header{
+		"CD": tanHeader(append(ref,
+			fitsio.Card{Name: "CD1_1", Value: cdelt1 * cos},
+			fitsio.Card{Name: "CD1_2", Value: -cdelt2 * sin},
+			fitsio.Card{Name: "CD2_1", Value: cdelt1 * sin},
+			fitsio.Card{Name: "CD2_2", Value: cdelt2 * cos},
+		)...),
+		"PC": tanHeader(append(ref,
+			fitsio.Card{Name: "CDELT1", Value: cdelt1},
+			fitsio.Card{Name: "CDELT2", Value: cdelt2},
+			fitsio.Card{Name: "PC1_1", Value: cos},
+			fitsio.Card{Name: "PC1_2", Value: -sin * cdelt2 / cdelt1},
+			fitsio.Card{Name: "PC2_1", Value: sin * cdelt1 / cdelt2},
+			fitsio.Card{Name: "PC2_2", Value: cos},
+		)...),
+		"CROTA2": tanHeader(append(ref,
+			fitsio.Card{Name: "CDELT1", Value: cdelt1},
+			fitsio.Card{Name: "CDELT2", Value: cdelt2},
+			fitsio.Card{Name: "CROTA2", Value: rho},
+		)...),
+	}
+
+	want, err := New(headers["CD"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, hdr := range headers {
+		w, err := New(hdr)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		for _, p := range [][2]float64{{1, 1}, {512, 512}, {1024, 1}, {300, 800}} {
+			ra, dec := w.PixelToWorld(p[0], p[1])
+			wra, wdec := want.PixelToWorld(p[0], p[1])
+			if math.Abs(ra-wra) > 1e-9 || math.Abs(dec-wdec) > 1e-9 {
+				t.Fatalf("%s: %v: got=(%v, %v), want=(%v, %v)", name, p, ra, dec, wra, wdec)
+			}
+
+			// Round trip.
+			x, y, ok := w.WorldToPixel(ra, dec)
+			if !ok || math.Abs(x-p[0]) > 1e-6 || math.Abs(y-p[1]) > 1e-6 {
+				t.Fatalf("%s: round trip of %v: got=(%v, %v, %v)", name, p, x, y, ok)
+			}
+
+			// In the gnomonic projection, the angular distance to the
+			// reference point is atan of the distance in the projection plane.
+			dx, dy := p[0]-512, p[1]-512
+			r := math.Hypot(cdelt1*dx, cdelt2*dy) * deg
+			if d := separation(ra, dec, 202.4696, 47.1952); math.Abs(d-math.Atan(r)/deg) > 1e-9 {
+				t.Fatalf("%s: %v: separation=%v, want %v", name, p, d, math.Atan(r)/deg)
+			}
+		}
+	}
+}
+
+func TestPole(t *testing.T) {
+	w, err := New(tanHeader(
+		fitsio.Card{Name: "CRPIX1", Value: 1},
+		fitsio.Card{Name: "CRPIX2", Value: 1},
+		fitsio.Card{Name: "CRVAL1", Value: 0.0},
+		fitsio.Card{Name: "CRVAL2", Value: 90.0},
+		fitsio.Card{Name: "CDELT1", Value: -1.0},
+		fitsio.Card{Name: "CDELT2", Value: 1.0},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ra, dec := w.PixelToWorld(1, 2)
+	if math.Abs(ra-180) > 1e-9 || math.Abs(dec-(90-math.Atan(deg)/deg)) > 1e-9 {
+		t.Fatalf("got=(%v, %v)", ra, dec)
+	}
+	if _, _, ok := w.WorldToPixel(0, -10); ok {
+		t.Fatalf("the southern hemisphere should not be projected")
+	}
+}
+
+func TestFormat(t *testing.T) {
+	for _, table := range []struct {
+		got, want string
+	}{
+		{got: FormatRA(202.4696), want: "13:29:52.704"},
+		{got: FormatRA(359.99999999), want: "00:00:00.000"},
+		{got: FormatDec(47.1952), want: "+47:11:42.72"},
+		{got: FormatDec(-0.5), want: "-00:30:00.00"},
+	} {
+		if table.got != table.want {
+			t.Fatalf("got=%q, want=%q", table.got, table.want)
+		}
+	}
+}
+
+// separation returns the angular distance between two positions, in
+// degrees.
+func separation(ra1, dec1, ra2, dec2 float64) float64 {
+	ra1, dec1, ra2, dec2 = ra1*deg, dec1*deg, ra2*deg, dec2*deg
+	s := math.Sin((dec2-dec1)/2)*math.Sin((dec2-dec1)/2) +
+		math.Cos(dec1)*math.Cos(dec2)*math.Sin((ra2-ra1)/2)*math.Sin((ra2-ra1)/2)
+	return 2 * math.Asin(math.Sqrt(s)) / deg
+}
+
+func TestParse(t *testing.T) {
+	for _, table := range []struct {
+		s     string
+		parse func(string) (float64, error)
+		want  float64
+	}{
+		{"13:29:52.704", ParseRA, 202.4696},
+		{"13 29 52.704", ParseRA, 202.4696},
+		{"202.4696", ParseRA, 202.4696},
+		{"+47:11:42.72", ParseDec, 47.1952},
+		{"-00:30:00", ParseDec, -0.5},
+		{"-0.5", ParseDec, -0.5},
+	} {
+		got, err := table.parse(table.s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(got-table.want) > 1e-9 {
+			t.Fatalf("%q: got=%v, want=%v", table.s, got, table.want)
+		}
+	}
+	if _, err := ParseDec("12:ab"); err == nil {
+		t.Fatalf("expected an error")
+	}
+}
+
+func TestAxis(t *testing.T) {
+	hdr := newHeader(
+		fitsio.Card{Name: "CTYPE3", Value: "VRAD    "},
+		fitsio.Card{Name: "CUNIT3", Value: "m/s"},
+		fitsio.Card{Name: "CRPIX3", Value: 1},
+		fitsio.Card{Name: "CRVAL3", Value: -2000.0},
+		fitsio.Card{Name: "CDELT3", Value: 500.0},
+	)
+	a, err := NewAxis(hdr, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.CType != "VRAD" || a.CUnit != "m/s" {
+		t.Fatalf("invalid axis: %+v", a)
+	}
+	if got := a.Value(5); got != 0 {
+		t.Fatalf("Value(5)=%v, want 0", got)
+	}
+	if _, err := NewAxis(hdr, 4); err == nil {
+		t.Fatalf("expected an error for a missing axis")
+	}
+}